@@ -0,0 +1,197 @@
+package broker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Outcomes of the OSB calls in the audit log
+const (
+	AuditOutcomeSucceeded = "succeeded"
+	AuditOutcomeAccepted  = "accepted"
+	AuditOutcomeExists    = "exists"
+	AuditOutcomeFailed    = "failed"
+)
+
+// AuditRecord is the record of an OSB call in the audit log.
+type AuditRecord struct {
+	Timestamp           time.Time                 `json:"timestamp"`
+	Operation           string                    `json:"operation"`
+	InstanceID          string                    `json:"instanceID,omitempty"`
+	BindingID           string                    `json:"bindingID,omitempty"`
+	ServiceID           string                    `json:"serviceID,omitempty"`
+	PlanID              string                    `json:"planID,omitempty"`
+	Namespace           string                    `json:"namespace,omitempty"`
+	Name                string                    `json:"name,omitempty"`
+	OriginatingIdentity *AuditOriginatingIdentity `json:"originatingIdentity,omitempty"`
+	APIVersion          string                    `json:"apiVersion,omitempty"`
+	Outcome             string                    `json:"outcome"`
+	StatusCode          int                       `json:"statusCode,omitempty"`
+	Error               string                    `json:"error,omitempty"`
+	DurationMillis      int64                     `json:"durationMillis"`
+
+	start time.Time
+}
+
+// AuditOriginatingIdentity is the originating identity of an OSB call. The value is kept as JSON, if it is.
+type AuditOriginatingIdentity struct {
+	Platform string      `json:"platform"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// auditLog writes the records of the OSB calls as JSON lines, and optionally records them
+// as Events on the KubeDB objects of the instances, if the calls change them.
+type auditLog struct {
+	mu  sync.Mutex
+	out io.Writer
+	// nil, if the calls are not recorded as Events
+	recorder record.EventRecorder
+}
+
+// newAuditLog returns the audit log written to the file at path, or to stdout if path is "-".
+// It returns nil if both the file and the Events are disabled.
+func newAuditLog(path string, recorder record.EventRecorder) (*auditLog, error) {
+	if path == "" && recorder == nil {
+		return nil, nil
+	}
+
+	l := &auditLog{recorder: recorder}
+	switch path {
+	case "":
+	case "-":
+		l.out = os.Stdout
+	default:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open audit log %s", path)
+		}
+		l.out = f
+	}
+	return l, nil
+}
+
+// beginAudit starts the record of an OSB call, that is written by endAudit once the call is done.
+func beginAudit(operation string, c *broker.RequestContext) *AuditRecord {
+	r := &AuditRecord{
+		Operation: operation,
+		Outcome:   AuditOutcomeSucceeded,
+		start:     time.Now(),
+	}
+	if c == nil || c.Request == nil {
+		return r
+	}
+
+	r.APIVersion = c.Request.Header.Get(osb.APIVersionHeader)
+	if identity, err := OriginatingIdentity(c.Request); err == nil {
+		r.OriginatingIdentity = &AuditOriginatingIdentity{
+			Platform: identity.Platform,
+			Value:    identity.Value,
+		}
+		var value interface{}
+		if json.Unmarshal([]byte(identity.Value), &value) == nil {
+			r.OriginatingIdentity.Value = value
+		}
+	}
+	return r
+}
+
+// endAudit writes the record of an OSB call with its outcome, it is a no-op if the audit log is disabled.
+func (b *Broker) endAudit(r *AuditRecord, err error) {
+	if b.audit == nil {
+		return
+	}
+
+	r.Timestamp = r.start.UTC()
+	r.DurationMillis = int64(time.Since(r.start) / time.Millisecond)
+	if err != nil {
+		r.Outcome = AuditOutcomeFailed
+		r.StatusCode = http.StatusInternalServerError
+		if httpErr, ok := osb.IsHTTPError(err); ok {
+			r.StatusCode = httpErr.StatusCode
+		}
+		r.Error = err.Error()
+	}
+
+	b.audit.write(r)
+	if b.audit.recorder != nil && r.Namespace != "" && r.Name != "" && r.ServiceID != "" {
+		b.audit.recordEvent(b.dbClient.ObjectReference(r.ServiceID, r.Name, r.Namespace), r)
+	}
+}
+
+func (l *auditLog) write(r *AuditRecord) {
+	if l.out == nil {
+		return
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		glog.Errorf("failed to write audit record of %s: %v", r.Operation, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(append(data, '\n')); err != nil {
+		glog.Errorf("failed to write audit record of %s: %v", r.Operation, err)
+	}
+}
+
+// eventOperations are the OSB calls those are recorded as Events, with the reasons of the Events.
+var eventOperations = map[string]string{
+	"provision":   "Provision",
+	"update":      "Update",
+	"deprovision": "Deprovision",
+	"bind":        "Bind",
+	"unbind":      "Unbind",
+}
+
+func (l *auditLog) recordEvent(ref *core.ObjectReference, r *AuditRecord) {
+	reason, found := eventOperations[r.Operation]
+	if !found || ref == nil {
+		return
+	}
+
+	user := "unknown user"
+	if r.OriginatingIdentity != nil {
+		if value, ok := r.OriginatingIdentity.Value.(map[string]interface{}); ok {
+			if name, ok := value["username"].(string); ok {
+				user = name
+			} else if id, ok := value["user_id"].(string); ok {
+				user = id
+			}
+		}
+	}
+	subject := "instance " + r.InstanceID
+	if r.BindingID != "" {
+		subject = "binding " + r.BindingID + " of " + subject
+	}
+
+	switch r.Outcome {
+	case AuditOutcomeFailed:
+		l.recorder.Eventf(ref, core.EventTypeWarning, reason+"Failed", "%s of %s by %s failed: %s", reason, subject, user, r.Error)
+	case AuditOutcomeAccepted:
+		l.recorder.Eventf(ref, core.EventTypeNormal, reason+"Started", "%s of %s by %s is in progress", reason, subject, user)
+	default:
+		l.recorder.Eventf(ref, core.EventTypeNormal, reason+"Succeeded", "%s of %s by %s succeeded", reason, subject, user)
+	}
+}
+
+// setInstance records the service, plan and KubeDB object of the instance of an OSB call.
+func (r *AuditRecord) setInstance(info *dbsvc.ProvisionInfo) {
+	r.ServiceID = info.ServiceID
+	r.PlanID = info.PlanID
+	r.Namespace = info.Namespace
+	r.Name = info.InstanceName
+}
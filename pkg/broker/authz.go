@@ -0,0 +1,142 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	authorization "k8s.io/api/authorization/v1"
+	core "k8s.io/api/core/v1"
+)
+
+// Platform of the originating identity of the requests from Kubernetes
+// ref: https://github.com/openservicebrokerapi/servicebroker/blob/master/profile.md#originating-identity-header
+const platformKubernetes = "kubernetes"
+
+// kubernetesUser is the originating identity of the requests from Kubernetes.
+type kubernetesUser struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid"`
+	Groups   []string            `json:"groups"`
+	Extra    map[string][]string `json:"extra"`
+}
+
+// authorize checks that the originating user of a request is allowed to perform the operation on the
+// resource through a SubjectAccessReview, so that the broker does not act with its own privileges on
+// behalf of any user. It returns 403 Forbidden, if the user is not allowed or can't be identified.
+func (b *Broker) authorize(identity *osb.OriginatingIdentity, c *broker.RequestContext, attrs authorization.ResourceAttributes) error {
+	if !b.authorizeRequests {
+		return nil
+	}
+	if err := requireAPIVersion(c, originatingIdentityAPIVersion, "Authorization of the originating identity"); err != nil {
+		return err
+	}
+
+	if identity == nil {
+		return forbiddenError("originating identity is required to %s", describeAccess(attrs))
+	}
+	if identity.Platform != platformKubernetes {
+		return forbiddenError("originating identity of platform %q can't be authorized to %s", identity.Platform, describeAccess(attrs))
+	}
+	var user kubernetesUser
+	if err := json.Unmarshal([]byte(identity.Value), &user); err != nil {
+		return badRequestError("invalid originating identity: %v", err)
+	}
+	if user.Username == "" {
+		return forbiddenError("originating identity without username can't be authorized to %s", describeAccess(attrs))
+	}
+
+	review := &authorization.SubjectAccessReview{
+		Spec: authorization.SubjectAccessReviewSpec{
+			ResourceAttributes: &attrs,
+			User:               user.Username,
+			UID:                user.UID,
+			Groups:             user.Groups,
+		},
+	}
+	if len(user.Extra) > 0 {
+		review.Spec.Extra = map[string]authorization.ExtraValue{}
+		for k, v := range user.Extra {
+			review.Spec.Extra[k] = v
+		}
+	}
+	review, err := b.kubeClient.AuthorizationV1().SubjectAccessReviews().Create(review)
+	if err != nil {
+		return errors.Wrapf(err, "failed to review access of user %s", user.Username)
+	}
+	if !review.Status.Allowed {
+		reason := ""
+		if review.Status.Reason != "" {
+			reason = ": " + review.Status.Reason
+		}
+		return forbiddenError("user %s is not allowed to %s%s", user.Username, describeAccess(attrs), reason)
+	}
+	return nil
+}
+
+// authorizeInstance checks that the originating user of a request is allowed to perform the verb on the KubeDB object of an instance.
+// The name of the object is empty, if it is yet to be created.
+func (b *Broker) authorizeInstance(identity *osb.OriginatingIdentity, c *broker.RequestContext, verb, serviceID, namespace, name string) error {
+	if !b.authorizeRequests {
+		return nil
+	}
+	resource, err := b.dbClient.Resource(serviceID)
+	if err != nil {
+		return err
+	}
+	return b.authorize(identity, c, authorization.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      verb,
+		Group:     resource.Group,
+		Resource:  resource.Resource,
+		Name:      name,
+	})
+}
+
+// authorizeBinding checks that the originating user of a request on a binding is allowed to read the credentials
+// of an instance, i.e. the Secrets in its namespace.
+func (b *Broker) authorizeBinding(identity *osb.OriginatingIdentity, c *broker.RequestContext, namespace string) error {
+	return b.authorize(identity, c, authorization.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "get",
+		Group:     core.GroupName,
+		Resource:  "secrets",
+	})
+}
+
+// OriginatingIdentity parses the originating identity header of a request the same way as osb-broker-lib does.
+func OriginatingIdentity(r *http.Request) (*osb.OriginatingIdentity, error) {
+	header := r.Header.Get(osb.OriginatingIdentityHeader)
+	if header == "" {
+		return nil, errors.New("unable to find originating identity")
+	}
+
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 {
+		return nil, errors.New("invalid originating identity header")
+	}
+	value, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid encoding for value of originating identity header")
+	}
+	return &osb.OriginatingIdentity{
+		Platform: parts[0],
+		Value:    string(value),
+	}, nil
+}
+
+func describeAccess(attrs authorization.ResourceAttributes) string {
+	resource := attrs.Resource
+	if attrs.Group != "" {
+		resource += "." + attrs.Group
+	}
+	if attrs.Name != "" {
+		resource += " " + attrs.Name
+	}
+	return fmt.Sprintf("%s %s in namespace %s", attrs.Verb, resource, attrs.Namespace)
+}
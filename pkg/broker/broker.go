@@ -1,62 +1,143 @@
 package broker
 
 import (
+	"fmt"
 	"net/http"
-	"sync"
+	"strings"
+	"text/template"
+	"time"
 
 	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
 	"github.com/golang/glog"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	svcat_cs "github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/clientset/typed/servicecatalog/v1beta1"
 	"github.com/pkg/errors"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // Broker provides an implementation of broker.Interface
 type Broker struct {
-	dbClient     *dbsvc.Client
+	dbClient   *dbsvc.Client
+	kubeClient kubernetes.Interface
+
+	// Client of Service Catalog, nil if the broker is not used with Service Catalog
 	svccatClient svcat_cs.ServicecatalogV1beta1Interface
 
+	// Records the operations performed on the instances
+	operations *operationStore
+
 	// Indicates if the broker should handle the requests asynchronously.
 	async bool
+	// How long a synchronous provisioning or update waits for the instance to be ready
+	provisionTimeout time.Duration
 
 	// The path for catalog
 	catalogPath string
 	// names of the catalog those will provided by the broker
 	catalogNames []string
 
-	// Synchronize go routines.
-	sync.RWMutex
+	// Serializes the operations on the same instance or binding
+	locks *keyedLock
+	// Coordinates the operations among the replicas of the broker, nil if it runs a single replica
+	leases *leaseLock
 
 	// Default namespace to run brokers if not specified during request
 	defaultNamespace string
+	// Template of the namespaces of the instances provisioned from Cloud Foundry
+	cfNamespaceTemplate *template.Template
+	// Restricts and rewrites the namespaces where the instances are provisioned
+	namespacePolicy *namespacePolicy
+	// Indicates if the originating users of the requests are authorized to perform them
+	authorizeRequests bool
+	// Records the OSB calls, nil if the audit log is disabled
+	audit *auditLog
 }
 
 var _ broker.Interface = &Broker{}
 
+// Error codes of the OSB API for a request on an instance with an operation in progress,
+// and for a synchronous request those are served only asynchronously
+var (
+	concurrencyErrorMessage   = dbsvc.ErrorConcurrencyError
+	asyncRequiredErrorMessage = dbsvc.ErrorAsyncRequired
+)
+
 func (b *Broker) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
+	services, err := b.GetServices(c)
+	if err != nil {
+		return nil, err
+	}
+
+	response := broker.CatalogResponse{}
+	for _, service := range services.Services {
+		response.Services = append(response.Services, service.Service)
+	}
+	return &response, nil
+}
+
+// CatalogResponse is sent as the response to a catalog request. Unlike broker.CatalogResponse,
+// it carries the fields of the services those are not supported by the OSB client library.
+type CatalogResponse struct {
+	Services []dbsvc.Service `json:"services"`
+}
+
+func (b *Broker) GetServices(c *broker.RequestContext) (resp *CatalogResponse, err error) {
+	audit := beginAudit("catalog", c)
+	defer func() { b.endAudit(audit, err) }()
+
 	// Your catalog broker logic goes here
 	services, err := b.dbClient.GetCatalog(b.catalogPath, b.catalogNames...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &broker.CatalogResponse{
-		CatalogResponse: osb.CatalogResponse{
-			Services: services,
-		},
+	// fetching instances and bindings is not known to the platforms of older versions
+	if !requestAPIVersion(c).AtLeast(fetchAPIVersion) {
+		for i := range services {
+			services[i].InstancesRetrievable = false
+			services[i].BindingsRetrievable = false
+		}
+	}
+
+	return &CatalogResponse{
+		Services: services,
 	}, nil
 }
 
-func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
-	b.Lock()
-	defer b.Unlock()
+func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (resp *broker.ProvisionResponse, err error) {
+	audit := beginAudit("provision", c)
+	audit.InstanceID, audit.ServiceID, audit.PlanID = request.InstanceID, request.ServiceID, request.PlanID
+	defer func() {
+		if resp != nil && resp.Async {
+			audit.Outcome = AuditOutcomeAccepted
+		}
+		if resp != nil && resp.Exists {
+			audit.Outcome = AuditOutcomeExists
+		}
+		b.endAudit(audit, err)
+	}()
+
+	// without a timeout to wait for, instances are provisioned only asynchronously
+	if b.async && b.provisionTimeout == 0 && !request.AcceptsIncomplete {
+		return nil, asyncRequiredError("Provisioning")
+	}
 
-	namespace := b.defaultNamespace
-	if ns, ok := request.Context["namespace"]; ok {
-		namespace = ns.(string)
+	unlock, err := b.lockInstance(request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	namespace, origin, err := b.targetNamespace(request.Context)
+	if err != nil {
+		return nil, err
+	}
+	audit.Namespace = namespace
+	if err := b.authorizeInstance(request.OriginatingIdentity, c, "create", request.ServiceID, namespace, ""); err != nil {
+		return nil, err
 	}
 
 	response := broker.ProvisionResponse{}
@@ -65,30 +146,13 @@ func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestConte
 		ServiceID:  request.ServiceID,
 		PlanID:     request.PlanID,
 		Params:     request.Parameters,
+		Labels:     origin,
 		Namespace:  namespace,
 	}
 
-	// use name of ServiceInstance as instance crd name
-	// ref: https://github.com/kubernetes-incubator/service-catalog/issues/2532
-	svcinstances, err := b.svccatClient.ServiceInstances(namespace).List(metav1.ListOptions{})
-	if kerr.IsNotFound(err) {
-		curProvisionInfo.InstanceName = "app-" + request.InstanceID
-	} else if err != nil {
+	curProvisionInfo.InstanceName, err = b.instanceName(request, namespace)
+	if err != nil {
 		return nil, err
-	} else {
-		if len(svcinstances.Items) == 0 {
-			curProvisionInfo.InstanceName = "app-" + request.InstanceID
-		} else {
-			for _, svcinstance := range svcinstances.Items {
-				if svcinstance.Spec.ExternalID == request.InstanceID {
-					curProvisionInfo.InstanceName = svcinstance.Name
-				}
-			}
-
-			if curProvisionInfo.InstanceName == "" {
-				return nil, errors.Errorf("failed get name of ServiceInstance %s/%s", namespace, request.InstanceID)
-			}
-		}
 	}
 
 	// Check to see if this is the same instance
@@ -97,10 +161,45 @@ func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestConte
 		return nil, err
 	}
 	if provisionInfo != nil {
-		if provisionInfo.Match(curProvisionInfo) {
-			response.Exists = true
-			glog.Infof("Instance %s is already exists", request.InstanceID)
-			return &response, nil
+		if b.dbClient.MatchProvisionInfo(provisionInfo, curProvisionInfo) {
+			audit.Name = provisionInfo.InstanceName
+			// an instance, whose provisioning has timed out, is waited for again instead of reported to exist
+			op, err := b.operations.Get(request.InstanceID, "")
+			if err != nil {
+				return nil, err
+			}
+			if op == nil || op.Type != OperationProvision || op.State == osb.StateSucceeded {
+				response.Exists = true
+				glog.Infof("Instance %s is already exists", request.InstanceID)
+				return &response, nil
+			}
+			// an object that has failed stays failed, so it is not waited for but has to be deprovisioned
+			state, description, err := b.dbClient.GetStatus(provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if state == osb.StateFailed {
+				description = fmt.Sprintf("%s, deprovision instance %q before provisioning it again", description, request.InstanceID)
+				return nil, osb.HTTPStatusCodeError{
+					StatusCode:  http.StatusConflict,
+					Description: &description,
+				}
+			}
+			if op.State == osb.StateFailed {
+				op = &Operation{
+					Type:         OperationProvision,
+					InstanceID:   request.InstanceID,
+					ServiceID:    request.ServiceID,
+					PlanID:       request.PlanID,
+					Namespace:    provisionInfo.Namespace,
+					InstanceName: provisionInfo.InstanceName,
+				}
+				if err := b.operations.Begin(op); err != nil {
+					return nil, err
+				}
+			}
+			glog.Infof("Provisioning of instance %q is not complete, retrying with operation %q", request.InstanceID, op.Key)
+			return b.completeProvision(op, request, provisionInfo.InstanceName, provisionInfo.Namespace)
 		} else {
 			// Instance ID in use, this is a conflict.
 			description := "InstanceID in use"
@@ -112,113 +211,771 @@ func (b *Broker) Provision(request *osb.ProvisionRequest, c *broker.RequestConte
 		}
 	}
 
+	// a paused instance is resumed under its own name
+	if err := b.dbClient.ResolveRestore(curProvisionInfo); err != nil {
+		return nil, err
+	}
+
+	op := &Operation{
+		Type:         OperationProvision,
+		InstanceID:   request.InstanceID,
+		ServiceID:    request.ServiceID,
+		PlanID:       request.PlanID,
+		Namespace:    curProvisionInfo.Namespace,
+		InstanceName: curProvisionInfo.InstanceName,
+	}
+	if err := b.operations.Begin(op); err != nil {
+		return nil, err
+	}
+
 	glog.Infof("Provisioning instance %q for %q/%q...", request.InstanceID, request.ServiceID, request.PlanID)
 	err = b.dbClient.Provision(*curProvisionInfo)
 	if err != nil {
 		glog.Errorln(err)
+		b.finishOperation(op, err, "")
 		return nil, err
 	}
-	glog.Infoln("Provisioning complete")
+	audit.Name = curProvisionInfo.InstanceName
+
+	return b.completeProvision(op, request, curProvisionInfo.InstanceName, curProvisionInfo.Namespace)
+}
 
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+// completeProvision responds to a provision request with the operation in progress, if it accepts incomplete
+// operations, otherwise it waits for the instance to be ready.
+func (b *Broker) completeProvision(op *Operation, request *osb.ProvisionRequest, instanceName, namespace string) (*broker.ProvisionResponse, error) {
+	response := broker.ProvisionResponse{}
+	if request.AcceptsIncomplete && b.async {
+		response.Async = true
+		response.OperationKey = &op.Key
+		glog.Infof("Provisioning of instance %q is in progress with operation %q", request.InstanceID, op.Key)
+	} else {
+		glog.Infof("Waiting for instance %q to be ready...", request.InstanceID)
+		err := b.dbClient.WaitForReady(request.ServiceID, instanceName, namespace, b.provisionTimeout)
+		if err != nil {
+			glog.Errorln(err)
+			b.finishOperation(op, err, "")
+			description := err.Error()
+			return nil, osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusInternalServerError,
+				Description: &description,
+			}
+		}
+		b.finishOperation(op, nil, "Provisioning complete")
+		glog.Infoln("Provisioning complete")
 	}
 
 	return &response, nil
 }
 
-func (b *Broker) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
-	// Your deprovision logic goes here
+func (b *Broker) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (resp *broker.DeprovisionResponse, err error) {
+	audit := beginAudit("deprovision", c)
+	audit.InstanceID, audit.ServiceID, audit.PlanID = request.InstanceID, request.ServiceID, request.PlanID
+	defer func() {
+		if resp != nil && resp.Async {
+			audit.Outcome = AuditOutcomeAccepted
+		}
+		b.endAudit(audit, err)
+	}()
 
-	// example implementation:
-	b.Lock()
-	defer b.Unlock()
+	unlock, err := b.lockInstance(request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	glog.Infof("Deprovisioning instance %q for %q/%q...", request.InstanceID, request.ServiceID, request.PlanID)
+	// the OSB API has no parameters for deprovisioning, so the termination policy is sent as a query parameter
+	policy, err := dbsvc.ParseTerminationPolicy(c.Request.FormValue(dbsvc.TerminationPolicyParam))
+	if err != nil {
+		return nil, err
+	}
+
 	provisionInfo, err := b.dbClient.GetProvisionInfo(request.InstanceID, request.ServiceID)
 	if err != nil {
 		return nil, err
 	} else if provisionInfo == nil {
-		return nil, errors.Errorf("Instance %q not found", request.InstanceID)
+		// the KubeDB object may be gone, while its DormantDatabase is left behind
+		provisionInfo, err = b.dbClient.GetDormantProvisionInfo(request.InstanceID)
+		if err != nil {
+			return nil, err
+		} else if provisionInfo == nil {
+			return nil, instanceGoneError(request.InstanceID)
+		}
+		glog.Infof("Instance %q is half-deleted, deleting its DormantDatabase...", request.InstanceID)
+	}
+	audit.setInstance(provisionInfo)
+	err = b.authorizeInstance(request.OriginatingIdentity, c, "delete", provisionInfo.ServiceID, provisionInfo.Namespace, provisionInfo.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{
+		Type:         OperationDeprovision,
+		InstanceID:   request.InstanceID,
+		ServiceID:    provisionInfo.ServiceID,
+		PlanID:       provisionInfo.PlanID,
+		Namespace:    provisionInfo.Namespace,
+		InstanceName: provisionInfo.InstanceName,
+	}
+	if err := b.operations.Begin(op); err != nil {
+		return nil, err
 	}
 
-	err = b.dbClient.Deprovision(request.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace)
+	deletion, err := b.dbClient.Deprovision(provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace, policy)
 	if err != nil {
 		glog.Errorln(err)
+		b.finishOperation(op, err, "")
 		return nil, err
 	}
 
 	response := broker.DeprovisionResponse{}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	if request.AcceptsIncomplete && b.async {
+		// the progress depends on the termination policy the instance is deleted with
+		if deletion != "" {
+			op.Parameters = map[string]interface{}{
+				dbsvc.TerminationPolicyParam: string(deletion),
+			}
+			if err := b.operations.Save(op); err != nil {
+				glog.Errorln(err)
+			}
+		}
+		response.Async = true
+		response.OperationKey = &op.Key
+		glog.Infof("Deprovisioning of instance %q is in progress with operation %q", request.InstanceID, op.Key)
+	} else {
+		// nothing left to poll, so the record of the instance goes away with it
+		if err := b.operations.Delete(request.InstanceID); err != nil {
+			glog.Errorln(err)
+		}
+		glog.Infoln("Deprovisioning complete")
 	}
-	glog.Infoln("Deprovisioning complete")
 
 	return &response, nil
 }
 
-func (b *Broker) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
-	// Your last-operation logic goes here
+func (b *Broker) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (resp *broker.LastOperationResponse, err error) {
+	audit := beginAudit("last_operation", c)
+	audit.InstanceID = request.InstanceID
+	defer func() { b.endAudit(audit, err) }()
 
-	return nil, nil
+	// osb-broker-lib looks for service_id and operation in the route variables,
+	// but they are sent as query parameters
+	serviceID := c.Request.FormValue(osb.VarKeyServiceID)
+	if request.ServiceID != nil {
+		serviceID = *request.ServiceID
+	}
+	key := osb.OperationKey(c.Request.FormValue(osb.VarKeyOperation))
+	if request.OperationKey != nil {
+		key = *request.OperationKey
+	}
+
+	glog.Infof("Getting last operation %q of instance %q for %q...", key, request.InstanceID, serviceID)
+	op, err := b.operations.Get(request.InstanceID, key)
+	if err != nil {
+		return nil, err
+	} else if op == nil {
+		// instances provisioned before operations were recorded are tracked by their status only
+		op = &Operation{
+			Type:       OperationProvision,
+			InstanceID: request.InstanceID,
+			ServiceID:  serviceID,
+			State:      osb.StateInProgress,
+		}
+	}
+
+	if !op.finished() {
+		if err := b.refreshOperation(op); err != nil {
+			return nil, err
+		}
+	}
+
+	if op.Type == OperationDeprovision && op.State == osb.StateSucceeded {
+		if err := b.operations.Delete(request.InstanceID); err != nil {
+			glog.Errorln(err)
+		}
+	}
+
+	response := broker.LastOperationResponse{
+		LastOperationResponse: osb.LastOperationResponse{
+			State:       op.State,
+			Description: &op.Description,
+		},
+	}
+	glog.Infof("Last operation %q of instance %q is %q: %s", op.Key, request.InstanceID, op.State, op.Description)
+
+	return &response, nil
 }
 
-func (b *Broker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
-	// Your bind logic goes here
+// refreshOperation resolves the progress of an unfinished operation from the state of the instance
+// and saves it, if the operation is recorded.
+func (b *Broker) refreshOperation(op *Operation) error {
+	if op.Type == OperationDeprovision && op.InstanceName != "" {
+		// a deprovision completes once every resource of the instance is removed, except the data of a paused instance
+		policy, _ := op.Parameters[dbsvc.TerminationPolicyParam].(string)
+		remaining, err := b.dbClient.RemainingResources(op.ServiceID, op.InstanceName, op.Namespace, api.TerminationPolicy(policy))
+		if err != nil {
+			glog.Errorln(err)
+			return err
+		}
+		if len(remaining) == 0 {
+			op.finish(osb.StateSucceeded, "Deprovisioning complete")
+		} else {
+			op.Description = fmt.Sprintf("Instance %q is being deleted, waiting for %s", op.InstanceID, strings.Join(remaining, ", "))
+		}
+		return b.operations.Save(op)
+	}
+
+	provisionInfo, err := b.dbClient.GetProvisionInfo(op.InstanceID, op.ServiceID)
+	if err != nil {
+		return err
+	}
+
+	if op.Type == OperationDeprovision {
+		// operations recorded without the name of the instance are tracked by its KubeDB object only
+		if provisionInfo == nil {
+			op.finish(osb.StateSucceeded, "Deprovisioning complete")
+		} else {
+			op.Description = fmt.Sprintf("Instance %q is being deleted", op.InstanceID)
+		}
+	} else {
+		// the object of an instance that has just been provisioned may not be cached yet, so the instance
+		// of a provision is looked up by the name recorded with its operation
+		serviceID, instanceName, namespace := op.ServiceID, op.InstanceName, op.Namespace
+		if provisionInfo != nil {
+			serviceID, instanceName, namespace = provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace
+		} else if instanceName == "" {
+			return instanceGoneError(op.InstanceID)
+		}
+
+		state, description, err := b.dbClient.GetStatus(serviceID, instanceName, namespace)
+		if kerr.IsNotFound(errors.Cause(err)) {
+			return instanceGoneError(op.InstanceID)
+		} else if err != nil {
+			glog.Errorln(err)
+			return err
+		}
 
-	// example implementation:
-	b.Lock()
-	defer b.Unlock()
+		if state == osb.StateInProgress {
+			op.Description = description
+		} else {
+			op.finish(state, description)
+		}
+	}
+
+	if op.Key == "" {
+		return nil
+	}
+	return b.operations.Save(op)
+}
+
+// finishOperation marks a synchronous operation as succeeded or failed depending on err and saves it.
+func (b *Broker) finishOperation(op *Operation, err error, description string) {
+	if err != nil {
+		op.finish(osb.StateFailed, err.Error())
+	} else {
+		op.finish(osb.StateSucceeded, description)
+	}
+
+	if err := b.operations.Save(op); err != nil {
+		glog.Errorln(err)
+	}
+}
+
+func badRequestError(format string, args ...interface{}) error {
+	description := fmt.Sprintf(format, args...)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
+
+func instanceNotFoundError(instanceID string) error {
+	description := fmt.Sprintf("Instance %q not found", instanceID)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusNotFound,
+		Description: &description,
+	}
+}
+
+func instanceGoneError(instanceID string) error {
+	description := fmt.Sprintf("Instance %q not found", instanceID)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusGone,
+		Description: &description,
+	}
+}
+
+func asyncRequiredError(operation string) error {
+	description := fmt.Sprintf("%s requires accepts_incomplete=true, as it is served only asynchronously", operation)
+	return osb.HTTPStatusCodeError{
+		StatusCode:   http.StatusUnprocessableEntity,
+		ErrorMessage: &asyncRequiredErrorMessage,
+		Description:  &description,
+	}
+}
+
+func bindingInProgressError(bindingID string) error {
+	description := fmt.Sprintf("Binding %q is in progress", bindingID)
+	return osb.HTTPStatusCodeError{
+		StatusCode:   http.StatusUnprocessableEntity,
+		ErrorMessage: &concurrencyErrorMessage,
+		Description:  &description,
+	}
+}
+
+func bindingGoneError(bindingID string) error {
+	description := fmt.Sprintf("Binding %q not found", bindingID)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusGone,
+		Description: &description,
+	}
+}
+
+func (b *Broker) Bind(request *osb.BindRequest, c *broker.RequestContext) (resp *broker.BindResponse, err error) {
+	audit := beginAudit("bind", c)
+	audit.InstanceID, audit.BindingID = request.InstanceID, request.BindingID
+	audit.ServiceID, audit.PlanID = request.ServiceID, request.PlanID
+	defer func() {
+		if resp != nil && resp.Async {
+			audit.Outcome = AuditOutcomeAccepted
+		}
+		b.endAudit(audit, err)
+	}()
+
+	async := request.AcceptsIncomplete && b.async && requestAPIVersion(c).AtLeast(asyncBindingAPIVersion)
+
+	unlock, err := b.lockBinding(request.InstanceID, request.BindingID)
+	if err != nil {
+		// the lock is held by the binding running in the background
+		if op, _ := b.operations.GetForBinding(request.InstanceID, request.BindingID, ""); async && op != nil && !op.finished() {
+			glog.Infof("Binding %q is in progress with operation %q", request.BindingID, op.Key)
+			return bindingInProgressResponse(op), nil
+		}
+		return nil, err
+	}
+	// unless the lock is handed over to a binding that runs in the background
+	defer func() {
+		if unlock != nil {
+			unlock()
+		}
+	}()
 
 	glog.Infof("Binding instance %q for %q/%q...", request.InstanceID, request.ServiceID, request.PlanID)
 	provisionInfo, err := b.dbClient.GetProvisionInfo(request.InstanceID, request.ServiceID)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Instance %q not found", request.InstanceID)
+		return nil, err
+	} else if provisionInfo == nil {
+		return nil, instanceNotFoundError(request.InstanceID)
+	}
+	audit.setInstance(provisionInfo)
+	if err := b.authorizeBinding(request.OriginatingIdentity, c, provisionInfo.Namespace); err != nil {
+		return nil, err
 	}
 
-	creds, err := b.dbClient.Bind(request.ServiceID, request.PlanID, request.Parameters, *provisionInfo)
+	// a binding that was interrupted is resumed instead of started again
+	op, err := b.operations.GetForBinding(request.InstanceID, request.BindingID, "")
+	if err != nil {
+		return nil, err
+	}
+	if op != nil && !op.finished() {
+		if !async {
+			return nil, bindingInProgressError(request.BindingID)
+		}
+		b.bindAsync(op, *provisionInfo, unlock)
+		unlock = nil
+		glog.Infof("Binding %q is in progress with operation %q", request.BindingID, op.Key)
+		return bindingInProgressResponse(op), nil
+	}
+
+	op = &Operation{
+		Type:       OperationBind,
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		ServiceID:  request.ServiceID,
+		PlanID:     request.PlanID,
+		Parameters: request.Parameters,
+	}
+	if err := b.operations.Begin(op); err != nil {
+		return nil, err
+	}
+
+	if async {
+		b.bindAsync(op, *provisionInfo, unlock)
+		unlock = nil
+		glog.Infof("Binding %q is in progress with operation %q", request.BindingID, op.Key)
+		return bindingInProgressResponse(op), nil
+	}
+
+	creds, err := b.bindInstance(op, *provisionInfo)
+	b.finishOperation(op, err, "Binding complete")
 	if err != nil {
 		glog.Errorln(err)
 		return nil, err
 	}
+	glog.Infoln("Binding complete")
 
-	response := broker.BindResponse{
+	return &broker.BindResponse{
 		BindResponse: osb.BindResponse{
 			Credentials: creds,
 		},
+	}, nil
+}
+
+func bindingInProgressResponse(op *Operation) *broker.BindResponse {
+	key := op.Key
+	return &broker.BindResponse{
+		BindResponse: osb.BindResponse{
+			Async:        true,
+			OperationKey: &key,
+		},
 	}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+}
+
+// bindInstance waits for the instance to be ready to be bound, i.e. its AppBinding is created,
+// and creates the binding.
+func (b *Broker) bindInstance(op *Operation, provisionInfo dbsvc.ProvisionInfo) (map[string]interface{}, error) {
+	err := b.dbClient.WaitForReady(provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace, b.provisionTimeout)
+	if err != nil {
+		return nil, err
 	}
-	glog.Infoln("Binding complete")
+	return b.dbClient.Bind(op.BindingID, provisionInfo.ServiceID, op.PlanID, op.Parameters, provisionInfo)
+}
+
+// bindAsync runs a binding in the background and saves its outcome in the operation.
+// The lock of the binding is released once the binding is complete.
+func (b *Broker) bindAsync(op *Operation, provisionInfo dbsvc.ProvisionInfo, unlock func()) {
+	go func() {
+		defer unlock()
+
+		_, err := b.bindInstance(op, provisionInfo)
+		if err != nil {
+			glog.Errorln(err)
+		} else {
+			glog.Infof("Binding %q complete", op.BindingID)
+		}
+		b.finishOperation(op, err, "Binding complete")
+	}()
+}
+
+func (b *Broker) BindingLastOperation(request *osb.BindingLastOperationRequest, c *broker.RequestContext) (resp *broker.LastOperationResponse, err error) {
+	audit := beginAudit("binding_last_operation", c)
+	audit.InstanceID, audit.BindingID = request.InstanceID, request.BindingID
+	defer func() { b.endAudit(audit, err) }()
+
+	if err := requireAPIVersion(c, asyncBindingAPIVersion, "Polling a binding"); err != nil {
+		return nil, err
+	}
+
+	var key osb.OperationKey
+	if request.OperationKey != nil {
+		key = *request.OperationKey
+	}
+
+	glog.Infof("Getting last operation %q of binding %q of instance %q...", key, request.BindingID, request.InstanceID)
+	op, err := b.operations.GetForBinding(request.InstanceID, request.BindingID, key)
+	if err != nil {
+		return nil, err
+	} else if op == nil {
+		return nil, bindingGoneError(request.BindingID)
+	}
+
+	if !op.finished() {
+		b.resumeBinding(op)
+	}
+
+	response := broker.LastOperationResponse{
+		LastOperationResponse: osb.LastOperationResponse{
+			State:       op.State,
+			Description: &op.Description,
+		},
+	}
+	glog.Infof("Last operation %q of binding %q is %q: %s", op.Key, request.BindingID, op.State, op.Description)
 
 	return &response, nil
 }
 
-func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
-	// nothing to do
+// resumeBinding resumes a binding that was interrupted by a restart of a broker, as binding is idempotent.
+// A binding is running, as long as its lock is held.
+func (b *Broker) resumeBinding(op *Operation) {
+	unlock, err := b.lockBinding(op.InstanceID, op.BindingID)
+	if err != nil {
+		return
+	}
+
+	// the binding may have completed before the lock is acquired
+	cur, err := b.operations.GetForBinding(op.InstanceID, op.BindingID, op.Key)
+	if err != nil || cur == nil || cur.finished() {
+		if cur != nil {
+			*op = *cur
+		}
+		unlock()
+		return
+	}
+
+	provisionInfo, err := b.dbClient.GetProvisionInfo(op.InstanceID, op.ServiceID)
+	if err != nil {
+		glog.Errorln(err)
+		unlock()
+		return
+	} else if provisionInfo == nil {
+		b.finishOperation(op, errors.Errorf("Instance %q not found", op.InstanceID), "")
+		unlock()
+		return
+	}
+
+	glog.Infof("Resuming operation %q of binding %q...", op.Key, op.BindingID)
+	b.bindAsync(cur, *provisionInfo, unlock)
+}
+
+func (b *Broker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (resp *broker.UnbindResponse, err error) {
+	audit := beginAudit("unbind", c)
+	audit.InstanceID, audit.BindingID = request.InstanceID, request.BindingID
+	audit.ServiceID, audit.PlanID = request.ServiceID, request.PlanID
+	defer func() { b.endAudit(audit, err) }()
+
+	unlock, err := b.lockBinding(request.InstanceID, request.BindingID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	glog.Infof("Unbinding instance %q for %q/%q...", request.InstanceID, request.ServiceID, request.PlanID)
-	response := broker.UnbindResponse{}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	op, err := b.operations.GetForBinding(request.InstanceID, request.BindingID, "")
+	if err != nil {
+		return nil, err
+	} else if op != nil && !op.finished() {
+		return nil, bindingInProgressError(request.BindingID)
+	}
+
+	provisionInfo, err := b.dbClient.GetProvisionInfo(request.InstanceID, request.ServiceID)
+	if err != nil {
+		return nil, err
+	} else if provisionInfo == nil {
+		// bindings are gone along with the instance
+		return nil, bindingGoneError(request.BindingID)
+	}
+	audit.setInstance(provisionInfo)
+	if err := b.authorizeBinding(request.OriginatingIdentity, c, provisionInfo.Namespace); err != nil {
+		return nil, err
+	}
+
+	if err := b.dbClient.Unbind(request.BindingID, *provisionInfo); err != nil {
+		glog.Errorln(err)
+		return nil, err
 	}
 	glog.Infoln("Unbinding complete")
 
-	return &response, nil
+	return &broker.UnbindResponse{}, nil
 }
 
-func (b *Broker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
-	// Not supported, do nothing
+func (b *Broker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (resp *broker.UpdateInstanceResponse, err error) {
+	audit := beginAudit("update", c)
+	audit.InstanceID, audit.ServiceID = request.InstanceID, request.ServiceID
+	defer func() {
+		if resp != nil && resp.Async {
+			audit.Outcome = AuditOutcomeAccepted
+		}
+		b.endAudit(audit, err)
+	}()
+
+	// without a timeout to wait for, instances are updated only asynchronously
+	if b.async && b.provisionTimeout == 0 && !request.AcceptsIncomplete {
+		return nil, asyncRequiredError("Updating")
+	}
+
+	unlock, err := b.lockInstance(request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	glog.Infof("Updating instance %q for %q...", request.InstanceID, request.ServiceID)
+	provisionInfo, err := b.dbClient.GetProvisionInfo(request.InstanceID, request.ServiceID)
+	if err != nil {
+		return nil, err
+	} else if provisionInfo == nil {
+		return nil, instanceNotFoundError(request.InstanceID)
+	}
+	audit.setInstance(provisionInfo)
+	if request.PlanID != nil {
+		audit.PlanID = *request.PlanID
+	}
+	err = b.authorizeInstance(request.OriginatingIdentity, c, "patch", provisionInfo.ServiceID, provisionInfo.Namespace, provisionInfo.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	newProvisionInfo := *provisionInfo
+	if request.PlanID != nil {
+		newProvisionInfo.PlanID = *request.PlanID
+	}
+	newProvisionInfo.Params, err = dbsvc.MergeParams(provisionInfo.Params, request.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{
+		Type:       OperationUpdate,
+		InstanceID: request.InstanceID,
+		ServiceID:  provisionInfo.ServiceID,
+		PlanID:     newProvisionInfo.PlanID,
+	}
+	if err := b.operations.Begin(op); err != nil {
+		return nil, err
+	}
+
+	err = b.dbClient.Update(newProvisionInfo)
+	if err != nil {
+		glog.Errorln(err)
+		b.finishOperation(op, err, "")
+		return nil, err
+	}
 
 	response := broker.UpdateInstanceResponse{}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	if request.AcceptsIncomplete && b.async {
+		response.Async = true
+		response.OperationKey = &op.Key
+		glog.Infof("Updating of instance %q is in progress with operation %q", request.InstanceID, op.Key)
+	} else {
+		glog.Infof("Waiting for instance %q to be updated...", request.InstanceID)
+		err := b.dbClient.WaitForUpdate(provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace, b.provisionTimeout)
+		if err != nil {
+			glog.Errorln(err)
+			b.finishOperation(op, err, "")
+			description := err.Error()
+			return nil, osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusInternalServerError,
+				Description: &description,
+			}
+		}
+		b.finishOperation(op, nil, "Update complete")
+		glog.Infoln("Update complete")
 	}
 
 	return &response, nil
 }
 
+// GetInstanceRequest represents a request to do a GET on a particular instance.
+type GetInstanceRequest struct {
+	InstanceID          string                   `json:"instance_id"`
+	OriginatingIdentity *osb.OriginatingIdentity `json:"originatingIdentity,omitempty"`
+}
+
+// GetInstanceResponse is sent as the response to doing a GET on a particular instance.
+// The databases have no dashboards, so the response has no dashboard_url, as in the provision response.
+type GetInstanceResponse struct {
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+func (b *Broker) GetInstance(request *GetInstanceRequest, c *broker.RequestContext) (resp *GetInstanceResponse, err error) {
+	audit := beginAudit("get_instance", c)
+	audit.InstanceID = request.InstanceID
+	defer func() { b.endAudit(audit, err) }()
+
+	if err := requireAPIVersion(c, fetchAPIVersion, "Fetching an instance"); err != nil {
+		return nil, err
+	}
+
+	serviceID := c.Request.FormValue(osb.VarKeyServiceID)
+
+	glog.Infof("Getting instance %q for %q...", request.InstanceID, serviceID)
+	provisionInfo, err := b.dbClient.GetProvisionInfo(request.InstanceID, serviceID)
+	if err != nil {
+		return nil, err
+	} else if provisionInfo == nil {
+		return nil, instanceNotFoundError(request.InstanceID)
+	}
+	audit.setInstance(provisionInfo)
+	err = b.authorizeInstance(request.OriginatingIdentity, c, "get", provisionInfo.ServiceID, provisionInfo.Namespace, provisionInfo.InstanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// an instance can not be fetched until it is provisioned, nor while it is being updated
+	op, err := b.operations.Get(request.InstanceID, "")
+	if err != nil {
+		return nil, err
+	}
+	if op != nil && !op.finished() {
+		if err := b.refreshOperation(op); err != nil {
+			return nil, err
+		}
+	}
+	if op != nil && op.State == osb.StateInProgress {
+		switch op.Type {
+		case OperationProvision:
+			return nil, instanceNotFoundError(request.InstanceID)
+		case OperationUpdate:
+			description := fmt.Sprintf("Instance %q is being updated", request.InstanceID)
+			return nil, osb.HTTPStatusCodeError{
+				StatusCode:   http.StatusUnprocessableEntity,
+				ErrorMessage: &concurrencyErrorMessage,
+				Description:  &description,
+			}
+		}
+	}
+
+	return &GetInstanceResponse{
+		ServiceID:  provisionInfo.ServiceID,
+		PlanID:     provisionInfo.PlanID,
+		Parameters: provisionInfo.Params,
+	}, nil
+}
+
+func (b *Broker) GetBinding(request *osb.GetBindingRequest, c *broker.RequestContext) (resp *osb.GetBindingResponse, err error) {
+	audit := beginAudit("get_binding", c)
+	audit.InstanceID, audit.BindingID = request.InstanceID, request.BindingID
+	defer func() { b.endAudit(audit, err) }()
+
+	if err := requireAPIVersion(c, fetchAPIVersion, "Fetching a binding"); err != nil {
+		return nil, err
+	}
+
+	serviceID := c.Request.FormValue(osb.VarKeyServiceID)
+
+	glog.Infof("Getting binding %q of instance %q for %q...", request.BindingID, request.InstanceID, serviceID)
+	provisionInfo, err := b.dbClient.GetProvisionInfo(request.InstanceID, serviceID)
+	if err != nil {
+		return nil, err
+	} else if provisionInfo == nil {
+		description := fmt.Sprintf("Binding %q not found", request.BindingID)
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusNotFound,
+			Description: &description,
+		}
+	}
+	audit.setInstance(provisionInfo)
+	// the request of osb-broker-lib does not carry the originating identity
+	identity, err := OriginatingIdentity(c.Request)
+	if err != nil {
+		glog.Infof("Unable to retrieve originating identity - %v", err)
+	}
+	if err := b.authorizeBinding(identity, c, provisionInfo.Namespace); err != nil {
+		return nil, err
+	}
+
+	creds, params, err := b.dbClient.GetBinding(request.BindingID, *provisionInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &osb.GetBindingResponse{
+		Credentials: creds,
+		Parameters:  params,
+	}, nil
+}
+
+// ValidateBrokerAPIVersion rejects the requests of an unknown major version or older than the minimum
+// supported version of the OSB API with 412 Precondition Failed.
 func (b *Broker) ValidateBrokerAPIVersion(version string) error {
+	v, err := ParseAPIVersion(version)
+	if err != nil {
+		return apiVersionError(err.Error())
+	}
+	if v.Major != minAPIVersion.Major || !v.AtLeast(minAPIVersion) {
+		return apiVersionError(fmt.Sprintf("OSB API version %s is not supported, minimum supported version is %s", v, minAPIVersion))
+	}
 	return nil
 }
@@ -1,22 +1,47 @@
 package broker
 
 import (
+	"time"
+
 	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
 	svcat_cs "github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/clientset/typed/servicecatalog/v1beta1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcore "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 )
 
 type config struct {
 	CatalogPath      string
 	CatalogNames     []string
 	Async            bool
+	ProvisionTimeout time.Duration
 	DefaultNamespace string
+	// Template of the namespaces of the instances provisioned from Cloud Foundry
+	CloudFoundryNamespaceTemplate string
+	// Restricts and rewrites the namespaces where the instances are provisioned
+	NamespacePolicy NamespacePolicy
+	// Indicates if the originating users of the requests are authorized to perform them
+	AuthorizeRequests bool
+	// Path of the audit log of the OSB calls, "-" for stdout, or empty to disable it
+	AuditLogPath string
+	// Indicates if the OSB calls those change the instances are recorded as Events on their KubeDB objects
+	AuditEvents bool
+	// Namespace where the broker keeps its own records
+	Namespace string
+	// Identity of this replica of the broker
+	Identity string
+	// Duration of the Leases those coordinate the replicas of the broker, zero disables the Leases
+	LeaseDuration time.Duration
 }
 
 type Config struct {
 	config
 
 	ClientConfig *rest.Config
+	KubeClient   kubernetes.Interface
 	DBClient     *dbsvc.Client
 	SvcCatClient svcat_cs.ServicecatalogV1beta1Interface
 }
@@ -28,12 +53,50 @@ func NewConfig(clientConfig *rest.Config) *Config {
 }
 
 func (c *Config) New() (*Broker, error) {
+	var leases *leaseLock
+	if c.LeaseDuration > 0 {
+		leases = newLeaseLock(c.KubeClient, c.Namespace, c.Identity, c.LeaseDuration)
+		c.DBClient.SetDormantGCLock(func() (func(), bool, error) {
+			return leases.TryLock(dormantGCLeaseKey)
+		})
+	}
+
+	cfNamespaceTemplate, err := parseNamespaceTemplate(c.CloudFoundryNamespaceTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	namespacePolicy, err := c.NamespacePolicy.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	var recorder record.EventRecorder
+	if c.AuditEvents {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcore.EventSinkImpl{Interface: c.KubeClient.CoreV1().Events(core.NamespaceAll)})
+		recorder = broadcaster.NewRecorder(scheme.Scheme, core.EventSource{Component: "appscode-service-broker"})
+	}
+	audit, err := newAuditLog(c.AuditLogPath, recorder)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Broker{
-		dbClient:         c.DBClient,
-		svccatClient:     c.SvcCatClient,
-		async:            c.Async,
-		catalogPath:      c.CatalogPath,
-		catalogNames:     c.CatalogNames,
-		defaultNamespace: c.DefaultNamespace,
+		dbClient:            c.DBClient,
+		kubeClient:          c.KubeClient,
+		svccatClient:        c.SvcCatClient,
+		operations:          newOperationStore(c.KubeClient, c.Namespace, c.DBClient.RedactParams),
+		locks:               newKeyedLock(),
+		leases:              leases,
+		async:               c.Async,
+		provisionTimeout:    c.ProvisionTimeout,
+		catalogPath:         c.CatalogPath,
+		catalogNames:        c.CatalogNames,
+		defaultNamespace:    c.DefaultNamespace,
+		cfNamespaceTemplate: cfNamespaceTemplate,
+		namespacePolicy:     namespacePolicy,
+		authorizeRequests:   c.AuthorizeRequests,
+		audit:               audit,
 	}, nil
 }
@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	coordination "k8s.io/api/coordination/v1beta1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	mu "kmodules.xyz/client-go/meta"
+)
+
+// leaseLock coordinates the operations on the same instance or binding among the replicas of the broker
+// through Leases in the namespace of the broker. A Lease is held while an operation is being processed
+// and is renewed periodically, so that the Lease of a replica that went away expires.
+type leaseLock struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	// Identity of the replica, that is recorded as the holder of its Leases
+	identity string
+	duration time.Duration
+}
+
+func newLeaseLock(kubeClient kubernetes.Interface, namespace, identity string, duration time.Duration) *leaseLock {
+	return &leaseLock{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		identity:   identity,
+		duration:   duration,
+	}
+}
+
+// Key of the Lease the DormantDatabases are collected under
+const dormantGCLeaseKey = "dormant-gc"
+
+func leaseName(key string) string {
+	return fmt.Sprintf("osb-lock-%x", sha1.Sum([]byte(key)))
+}
+
+// TryLock acquires the Lease of the key. It returns false, if the Lease is held by another replica.
+// The returned function releases the Lease.
+func (l *leaseLock) TryLock(key string) (func(), bool, error) {
+	name := leaseName(key)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(l.duration / time.Second)
+
+	leases := l.kubeClient.CoordinationV1beta1().Leases(l.namespace)
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		lease = &coordination.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: l.namespace,
+				Labels: map[string]string{
+					mu.ManagedByLabelKey: "appscode-service-broker",
+				},
+			},
+			Spec: coordination.LeaseSpec{
+				HolderIdentity:       &l.identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		lease, err = leases.Create(lease)
+		if kerr.IsAlreadyExists(err) {
+			return nil, false, nil
+		}
+	} else if err == nil {
+		if l.heldByOther(lease) {
+			return nil, false, nil
+		}
+		lease.Spec.HolderIdentity = &l.identity
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		// the update fails, if another replica has acquired the Lease in the meantime
+		lease, err = leases.Update(lease)
+		if kerr.IsConflict(err) {
+			return nil, false, nil
+		}
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to acquire lease %s/%s", l.namespace, name)
+	}
+
+	stopCh := make(chan struct{})
+	go wait.Until(func() { l.renew(name) }, l.duration/3, stopCh)
+
+	return func() {
+		close(stopCh)
+		l.release(name)
+	}, true, nil
+}
+
+func (l *leaseLock) heldByOther(lease *coordination.Lease) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || *spec.HolderIdentity == l.identity {
+		return false
+	}
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).After(time.Now())
+}
+
+func (l *leaseLock) renew(name string) {
+	leases := l.kubeClient.CoordinationV1beta1().Leases(l.namespace)
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("failed to renew lease %s/%s: %v", l.namespace, name, err)
+		return
+	}
+	if l.heldByOther(lease) {
+		glog.Errorf("lease %s/%s is taken over by %s", l.namespace, name, *lease.Spec.HolderIdentity)
+		return
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(lease); err != nil {
+		glog.Errorf("failed to renew lease %s/%s: %v", l.namespace, name, err)
+	}
+}
+
+// release deletes the Lease, unless it is taken over by another replica.
+func (l *leaseLock) release(name string) {
+	leases := l.kubeClient.CoordinationV1beta1().Leases(l.namespace)
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return
+	} else if err != nil {
+		glog.Errorf("failed to release lease %s/%s: %v", l.namespace, name, err)
+		return
+	}
+	if l.heldByOther(lease) {
+		return
+	}
+
+	err = leases.Delete(name, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{
+			UID:             &lease.UID,
+			ResourceVersion: &lease.ResourceVersion,
+		},
+	})
+	if err != nil && !kerr.IsNotFound(err) && !kerr.IsConflict(err) {
+		glog.Errorf("failed to release lease %s/%s: %v", l.namespace, name, err)
+	}
+}
@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// keyedLock serializes the operations on the same key, i.e. an instance or a binding, while the
+// operations on different keys proceed in parallel. An operation does not wait for the lock,
+// it is rejected instead, as the platform retries it later.
+type keyedLock struct {
+	mu     sync.Mutex
+	locked map[string]struct{}
+}
+
+func newKeyedLock() *keyedLock {
+	return &keyedLock{
+		locked: make(map[string]struct{}),
+	}
+}
+
+// TryLock locks the key. It returns false, if the key is already locked.
+func (l *keyedLock) TryLock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.locked[key]; found {
+		return false
+	}
+	l.locked[key] = struct{}{}
+	return true
+}
+
+func (l *keyedLock) Unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.locked, key)
+}
+
+// lockInstance locks an instance for an operation. It returns 422 ConcurrencyError,
+// if another operation on the instance is being processed.
+func (b *Broker) lockInstance(instanceID string) (func(), error) {
+	return b.lock(instanceID, fmt.Sprintf("Another operation on instance %q is in progress", instanceID))
+}
+
+// lockBinding locks a binding for an operation. The bindings of an instance are locked
+// independently of each other and of the instance.
+func (b *Broker) lockBinding(instanceID, bindingID string) (func(), error) {
+	return b.lock(instanceID+"/"+bindingID, fmt.Sprintf("Another operation on binding %q is in progress", bindingID))
+}
+
+// lock locks the key in this broker and, if the broker runs with multiple replicas, acquires its Lease.
+func (b *Broker) lock(key, description string) (func(), error) {
+	concurrencyError := osb.HTTPStatusCodeError{
+		StatusCode:   http.StatusUnprocessableEntity,
+		ErrorMessage: &concurrencyErrorMessage,
+		Description:  &description,
+	}
+
+	if !b.locks.TryLock(key) {
+		return nil, concurrencyError
+	}
+	if b.leases == nil {
+		return func() { b.locks.Unlock(key) }, nil
+	}
+
+	release, ok, err := b.leases.TryLock(key)
+	if err != nil || !ok {
+		b.locks.Unlock(key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, concurrencyError
+	}
+	return func() {
+		release()
+		b.locks.Unlock(key)
+	}, nil
+}
@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func TestKeyedLock(t *testing.T) {
+	l := newKeyedLock()
+	if !l.TryLock("a") {
+		t.Fatal("expected a to be locked")
+	}
+	if l.TryLock("a") {
+		t.Error("expected a to be locked once only")
+	}
+	if !l.TryLock("b") {
+		t.Error("expected b to be locked along with a")
+	}
+	l.Unlock("a")
+	if !l.TryLock("a") {
+		t.Error("expected a to be locked again once it is unlocked")
+	}
+}
+
+func TestLock(t *testing.T) {
+	b := &Broker{locks: newKeyedLock()}
+
+	unlock, err := b.lockInstance("instance")
+	if err != nil {
+		t.Fatalf("expected instance to be locked, found %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		lock  func() (func(), error)
+		valid bool
+	}{
+		{
+			name: "same instance",
+			lock: func() (func(), error) { return b.lockInstance("instance") },
+		},
+		{
+			name:  "another instance",
+			lock:  func() (func(), error) { return b.lockInstance("other") },
+			valid: true,
+		},
+		{
+			name:  "binding of the instance",
+			lock:  func() (func(), error) { return b.lockBinding("instance", "binding") },
+			valid: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			release, err := c.lock()
+			if c.valid {
+				if err != nil {
+					t.Fatalf("expected lock to be acquired, found %v", err)
+				}
+				release()
+				return
+			}
+			e, ok := osb.IsHTTPError(err)
+			if !ok || e.StatusCode != http.StatusUnprocessableEntity || e.ErrorMessage == nil || *e.ErrorMessage != concurrencyErrorMessage {
+				t.Errorf("expected %s with status code %d, found %v", concurrencyErrorMessage, http.StatusUnprocessableEntity, err)
+			}
+		})
+	}
+
+	unlock()
+	if unlock, err = b.lockInstance("instance"); err != nil {
+		t.Errorf("expected instance to be locked again once it is unlocked, found %v", err)
+	}
+	unlock()
+}
+
+func TestLockBinding(t *testing.T) {
+	b := &Broker{locks: newKeyedLock()}
+
+	unlock, err := b.lockBinding("instance", "binding")
+	if err != nil {
+		t.Fatalf("expected binding to be locked, found %v", err)
+	}
+	defer unlock()
+
+	if _, err := b.lockBinding("instance", "binding"); err == nil {
+		t.Error("expected binding to be locked once only")
+	}
+	release, err := b.lockBinding("instance", "other")
+	if err != nil {
+		t.Fatalf("expected another binding of the instance to be locked, found %v", err)
+	}
+	release()
+}
@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Key of the OSB context that holds the name of an instance on the platform
+const contextInstanceName = "instance_name"
+
+// instanceName returns the name of the KubeDB object of an instance. The name of the instance in the
+// OSB context is used, if it is a valid object name. Otherwise, the name of the ServiceInstance is used,
+// if the broker is used with Service Catalog. As a last resort, a name is generated from the instance id.
+func (b *Broker) instanceName(request *osb.ProvisionRequest, namespace string) (string, error) {
+	if name, ok := request.Context[contextInstanceName].(string); ok && len(validation.IsDNS1035Label(name)) == 0 {
+		return name, nil
+	}
+
+	// ref: https://github.com/kubernetes-incubator/service-catalog/issues/2532
+	if b.svccatClient != nil {
+		svcinstances, err := b.svccatClient.ServiceInstances(namespace).List(metav1.ListOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return "", err
+		}
+		if err == nil {
+			for _, svcinstance := range svcinstances.Items {
+				if svcinstance.Spec.ExternalID == request.InstanceID {
+					return svcinstance.Name, nil
+				}
+			}
+		}
+		glog.Infof("No ServiceInstance found for instance %q in namespace %s", request.InstanceID, namespace)
+	}
+
+	return generateInstanceName(request.InstanceID), nil
+}
+
+// generateInstanceName derives a DNS-safe name from an instance id.
+// Instance ids those do not make a valid name are hashed.
+func generateInstanceName(instanceID string) string {
+	name := "app-" + strings.ToLower(instanceID)
+	if len(validation.IsDNS1035Label(name)) == 0 {
+		return name
+	}
+	return fmt.Sprintf("app-%x", sha1.Sum([]byte(instanceID)))[:20]
+}
@@ -0,0 +1,313 @@
+package broker
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appscode/go/crypto/rand"
+	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
+	"github.com/pkg/errors"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	core_util "kmodules.xyz/client-go/core/v1"
+	mu "kmodules.xyz/client-go/meta"
+)
+
+type OperationType string
+
+const (
+	OperationProvision   OperationType = "provision"
+	OperationUpdate      OperationType = "update"
+	OperationDeprovision OperationType = "deprovision"
+	OperationBind        OperationType = "bind"
+
+	// Number of operations those are kept in the record of an instance
+	maxOperationsPerInstance = 10
+)
+
+// Operation is the record of an OSB operation performed on an instance.
+type Operation struct {
+	Key        osb.OperationKey `json:"key"`
+	Type       OperationType    `json:"type"`
+	InstanceID string           `json:"instanceID"`
+	BindingID  string           `json:"bindingID,omitempty"`
+	ServiceID  string           `json:"serviceID"`
+	PlanID     string           `json:"planID,omitempty"`
+	// Namespace and name of the instance, those are needed to track a provision before its KubeDB object is cached
+	// and a deprovision after the object is gone
+	Namespace    string                 `json:"namespace,omitempty"`
+	InstanceName string                 `json:"instanceName,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	State        osb.LastOperationState `json:"state"`
+	Description  string                 `json:"description,omitempty"`
+	// Sequence orders the operations of an instance, the start times only have a resolution of a second
+	Sequence  int64        `json:"sequence,omitempty"`
+	StartTime metav1.Time  `json:"startTime"`
+	EndTime   *metav1.Time `json:"endTime,omitempty"`
+}
+
+// before reports whether the operation has begun before another one. The operations recorded without
+// a sequence are ordered by their start times.
+func (op *Operation) before(other *Operation) bool {
+	if op.Sequence != other.Sequence {
+		return op.Sequence < other.Sequence
+	}
+	return op.StartTime.Before(&other.StartTime)
+}
+
+func (op *Operation) finished() bool {
+	return op.State == osb.StateSucceeded || op.State == osb.StateFailed
+}
+
+func (op *Operation) finish(state osb.LastOperationState, description string) {
+	now := metav1.Now()
+	op.State = state
+	op.Description = description
+	op.EndTime = &now
+}
+
+// operationStore persists the operations of every instance in a ConfigMap, so that
+// the progress of an operation can be resolved by its key even after a restart of the broker.
+type operationStore struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	// Redacts the sensitive parameters of the operations before they are recorded
+	redact func(params map[string]interface{}) map[string]interface{}
+}
+
+func newOperationStore(kubeClient kubernetes.Interface, namespace string, redact func(map[string]interface{}) map[string]interface{}) *operationStore {
+	return &operationStore{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		redact:     redact,
+	}
+}
+
+// recordName returns the name of the ConfigMap that holds the operations of an instance.
+// Instance ids those are not valid object names are hashed.
+func recordName(instanceID string) string {
+	name := "osb-instance-" + strings.ToLower(instanceID)
+	if len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+	return fmt.Sprintf("osb-instance-%x", sha1.Sum([]byte(instanceID)))
+}
+
+// Begin assigns a new key and the next sequence number of its instance to the operation, marks it
+// in progress and saves it. The sequence is reserved by updating the record at the version it is read
+// from, so that the operations begun concurrently on an instance, e.g. by its bindings, get distinct ones.
+func (s *operationStore) Begin(op *Operation) error {
+	op.Key = osb.OperationKey(fmt.Sprintf("%s-%s", op.Type, rand.Characters(10)))
+	op.State = osb.StateInProgress
+	op.StartTime = metav1.Now()
+	op.EndTime = nil
+
+	if err := s.ensureRecord(op.InstanceID); err != nil {
+		return err
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(recordName(op.InstanceID), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		op.Sequence = nextSequence(cm.Data)
+		data, err := s.marshal(op)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[string(op.Key)] = data
+		pruneOperations(cm.Data)
+		_, err = s.kubeClient.CoreV1().ConfigMaps(s.namespace).Update(cm)
+		return err
+	})
+	return errors.Wrapf(err, "failed to save operation %q of instance %q", op.Key, op.InstanceID)
+}
+
+// nextSequence returns the sequence number that follows the ones of the recorded operations.
+func nextSequence(data map[string]string) int64 {
+	var last int64
+	for _, v := range data {
+		var op Operation
+		if err := json.Unmarshal([]byte(v), &op); err == nil && op.Sequence > last {
+			last = op.Sequence
+		}
+	}
+	return last + 1
+}
+
+// ensureRecord creates the record of an instance, unless it exists already.
+func (s *operationStore) ensureRecord(instanceID string) error {
+	_, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Create(&core.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      recordName(instanceID),
+			Namespace: s.namespace,
+			Labels:    recordLabels(instanceID),
+		},
+	})
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create operations of instance %q", instanceID)
+	}
+	return nil
+}
+
+// recordLabels returns the labels of the record of an instance.
+func recordLabels(instanceID string) map[string]string {
+	labels := map[string]string{
+		mu.ManagedByLabelKey: "appscode-service-broker",
+	}
+	if len(validation.IsValidLabelValue(instanceID)) == 0 {
+		labels[dbsvc.InstanceKey] = instanceID
+	}
+	return labels
+}
+
+// marshal encodes the operation for its record. The sensitive parameters are not recorded,
+// so an operation that is resumed from its record runs with them redacted.
+func (s *operationStore) marshal(op *Operation) (string, error) {
+	recorded := *op
+	if s.redact != nil {
+		recorded.Parameters = s.redact(op.Parameters)
+	}
+	data, err := json.Marshal(recorded)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not marshal operation %q of instance %q", op.Key, op.InstanceID)
+	}
+	return string(data), nil
+}
+
+// Save writes the operation into the record of its instance.
+func (s *operationStore) Save(op *Operation) error {
+	data, err := s.marshal(op)
+	if err != nil {
+		return err
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      recordName(op.InstanceID),
+		Namespace: s.namespace,
+	}
+	_, _, err = core_util.CreateOrPatchConfigMap(s.kubeClient, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		if in.Labels == nil {
+			in.Labels = make(map[string]string)
+		}
+		for k, v := range recordLabels(op.InstanceID) {
+			in.Labels[k] = v
+		}
+		if in.Data == nil {
+			in.Data = make(map[string]string)
+		}
+		in.Data[string(op.Key)] = data
+		pruneOperations(in.Data)
+		return in
+	})
+	return errors.Wrapf(err, "failed to save operation %q of instance %q", op.Key, op.InstanceID)
+}
+
+// Get returns the operation of an instance with the given key. If the key is empty, the latest
+// operation on the instance (not on its bindings) is returned. It returns nil if no operation is found.
+func (s *operationStore) Get(instanceID string, key osb.OperationKey) (*Operation, error) {
+	ops, err := s.list(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Operation
+	for i := range ops {
+		if key != "" {
+			if ops[i].Key == key {
+				return &ops[i], nil
+			}
+			continue
+		}
+		if ops[i].BindingID == "" && (latest == nil || latest.before(&ops[i])) {
+			latest = &ops[i]
+		}
+	}
+	return latest, nil
+}
+
+// GetForBinding returns the operation of a binding with the given key. If the key is empty, the latest
+// operation on the binding is returned. It returns nil if no operation is found.
+func (s *operationStore) GetForBinding(instanceID, bindingID string, key osb.OperationKey) (*Operation, error) {
+	ops, err := s.list(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Operation
+	for i := range ops {
+		if ops[i].BindingID != bindingID {
+			continue
+		}
+		if key != "" {
+			if ops[i].Key == key {
+				return &ops[i], nil
+			}
+			continue
+		}
+		if latest == nil || latest.before(&ops[i]) {
+			latest = &ops[i]
+		}
+	}
+	return latest, nil
+}
+
+// Delete removes the record of an instance along with all of its operations.
+func (s *operationStore) Delete(instanceID string) error {
+	err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Delete(recordName(instanceID), &metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete operations of instance %q", instanceID)
+	}
+	return nil
+}
+
+func (s *operationStore) list(instanceID string) ([]Operation, error) {
+	cm, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(recordName(instanceID), metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to get operations of instance %q", instanceID)
+	}
+
+	ops := make([]Operation, 0, len(cm.Data))
+	for key, data := range cm.Data {
+		var op Operation
+		if err := json.Unmarshal([]byte(data), &op); err != nil {
+			return nil, errors.Wrapf(err, "could not unmarshal operation %q of instance %q", key, instanceID)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// pruneOperations drops the oldest finished operations, once a record holds more than maxOperationsPerInstance.
+func pruneOperations(data map[string]string) {
+	if len(data) <= maxOperationsPerInstance {
+		return
+	}
+
+	var finished []Operation
+	for _, v := range data {
+		var op Operation
+		if err := json.Unmarshal([]byte(v), &op); err == nil && op.finished() {
+			finished = append(finished, op)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].before(&finished[j])
+	})
+	for i := 0; i < len(finished) && len(data) > maxOperationsPerInstance; i++ {
+		delete(data, string(finished[i].Key))
+	}
+}
@@ -0,0 +1,160 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordData encodes the operations into the data of a record.
+func recordData(t *testing.T, ops ...Operation) map[string]string {
+	t.Helper()
+	data := make(map[string]string, len(ops))
+	for _, op := range ops {
+		v, err := json.Marshal(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[string(op.Key)] = string(v)
+	}
+	return data
+}
+
+func operations(n int, state osb.LastOperationState) []Operation {
+	ops := make([]Operation, 0, n)
+	for i := 1; i <= n; i++ {
+		ops = append(ops, Operation{
+			Key:      osb.OperationKey(fmt.Sprintf("%s-%d", state, i)),
+			State:    state,
+			Sequence: int64(i),
+		})
+	}
+	return ops
+}
+
+func TestPruneOperations(t *testing.T) {
+	cases := []struct {
+		name    string
+		ops     []Operation
+		removed []string
+	}{
+		{
+			name: "within the limit",
+			ops:  operations(maxOperationsPerInstance, osb.StateSucceeded),
+		},
+		{
+			name:    "oldest finished operations removed",
+			ops:     operations(maxOperationsPerInstance+2, osb.StateSucceeded),
+			removed: []string{"succeeded-1", "succeeded-2"},
+		},
+		{
+			name: "operations in progress kept",
+			ops:  operations(maxOperationsPerInstance+2, osb.StateInProgress),
+		},
+		{
+			name: "failed operations removed along with the succeeded ones",
+			ops: append(operations(maxOperationsPerInstance, osb.StateInProgress), Operation{
+				Key:      "failed",
+				State:    osb.StateFailed,
+				Sequence: maxOperationsPerInstance + 1,
+			}),
+			removed: []string{"failed"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := recordData(t, c.ops...)
+			pruneOperations(data)
+
+			if expected := len(c.ops) - len(c.removed); len(data) != expected {
+				t.Errorf("expected %d operations, found %d", expected, len(data))
+			}
+			for _, key := range c.removed {
+				if _, found := data[key]; found {
+					t.Errorf("expected operation %q to be removed", key)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationBefore(t *testing.T) {
+	now := time.Now()
+	earlier := metav1.NewTime(now.Add(-time.Minute))
+	later := metav1.NewTime(now)
+
+	cases := []struct {
+		name     string
+		op       Operation
+		other    Operation
+		expected bool
+	}{
+		{
+			name:     "by sequence",
+			op:       Operation{Sequence: 1, StartTime: later},
+			other:    Operation{Sequence: 2, StartTime: earlier},
+			expected: true,
+		},
+		{
+			name:  "by sequence of the other",
+			op:    Operation{Sequence: 2, StartTime: earlier},
+			other: Operation{Sequence: 1, StartTime: later},
+		},
+		{
+			name:     "by start time without sequence",
+			op:       Operation{StartTime: earlier},
+			other:    Operation{StartTime: later},
+			expected: true,
+		},
+		{
+			name:  "same start time without sequence",
+			op:    Operation{StartTime: later},
+			other: Operation{StartTime: later},
+		},
+	}
+
+	for _, c := range cases {
+		if found := c.op.before(&c.other); found != c.expected {
+			t.Errorf("%s: expected %t, found %t", c.name, c.expected, found)
+		}
+	}
+}
+
+func TestNextSequence(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     map[string]string
+		expected int64
+	}{
+		{
+			name:     "empty record",
+			expected: 1,
+		},
+		{
+			name:     "after the last operation",
+			data:     recordData(t, operations(3, osb.StateSucceeded)...),
+			expected: 4,
+		},
+		{
+			name:     "operations without sequence",
+			data:     recordData(t, Operation{Key: "a"}, Operation{Key: "b"}),
+			expected: 1,
+		},
+		{
+			name:     "invalid operations skipped",
+			data:     map[string]string{"a": "invalid", "b": `{"key":"b","sequence":7}`},
+			expected: 8,
+		},
+	}
+
+	for _, c := range cases {
+		if found := nextSequence(c.data); found != c.expected {
+			t.Errorf("%s: expected sequence %d, found %d", c.name, c.expected, found)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package broker
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	mu "kmodules.xyz/client-go/meta"
+)
+
+const (
+	// Platform of the OSB context those requests are mapped to namespaces
+	// ref: https://github.com/openservicebrokerapi/servicebroker/blob/master/profile.md#context-object
+	platformCloudFoundry = "cloudfoundry"
+
+	// Namespace template that is used, if none is configured
+	DefaultCloudFoundryNamespaceTemplate = "cf-{{ .SpaceGUID }}"
+)
+
+// CloudFoundryContext is the OSB context of the requests from Cloud Foundry.
+// The fields are available to the template of the namespace of Cloud Foundry instances.
+type CloudFoundryContext struct {
+	OrganizationGUID string
+	OrganizationName string
+	SpaceGUID        string
+	SpaceName        string
+}
+
+func cloudFoundryContext(context map[string]interface{}) CloudFoundryContext {
+	value := func(key string) string {
+		v, _ := context[key].(string)
+		return v
+	}
+	return CloudFoundryContext{
+		OrganizationGUID: value("organization_guid"),
+		OrganizationName: value("organization_name"),
+		SpaceGUID:        value("space_guid"),
+		SpaceName:        value("space_name"),
+	}
+}
+
+func parseNamespaceTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultCloudFoundryNamespaceTemplate
+	}
+	tpl, err := template.New("namespace").Funcs(template.FuncMap{
+		"lower": strings.ToLower,
+	}).Parse(text)
+	return tpl, errors.Wrapf(err, "invalid namespace template %q", text)
+}
+
+// targetNamespace returns the namespace where an instance is provisioned, along with the labels
+// those record the origin of the instance. The namespace of a Cloud Foundry instance is rendered
+// from its organization and space, and is created if it does not exist. The namespace is rewritten
+// and checked against the namespace policy of the broker.
+func (b *Broker) targetNamespace(context map[string]interface{}) (string, map[string]string, error) {
+	platform, _ := context["platform"].(string)
+
+	var namespace string
+	origin := map[string]string{}
+	switch platform {
+	case platformCloudFoundry:
+		cf := cloudFoundryContext(context)
+		if cf.OrganizationGUID == "" || cf.SpaceGUID == "" {
+			return "", nil, badRequestError("organization_guid and space_guid are required in the context of Cloud Foundry")
+		}
+
+		var buf bytes.Buffer
+		if err := b.cfNamespaceTemplate.Execute(&buf, cf); err != nil {
+			return "", nil, errors.Wrap(err, "failed to render the namespace of a Cloud Foundry instance")
+		}
+		namespace = strings.TrimSpace(buf.String())
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return "", nil, badRequestError("invalid namespace %q for space %q: %s", namespace, cf.SpaceGUID, strings.Join(errs, ", "))
+		}
+
+		origin[dbsvc.PlatformKey] = platformCloudFoundry
+		if len(validation.IsValidLabelValue(cf.OrganizationGUID)) == 0 {
+			origin[dbsvc.CloudFoundryOrganizationKey] = cf.OrganizationGUID
+		}
+		if len(validation.IsValidLabelValue(cf.SpaceGUID)) == 0 {
+			origin[dbsvc.CloudFoundrySpaceKey] = cf.SpaceGUID
+		}
+	default:
+		namespace = b.defaultNamespace
+		if ns, ok := context["namespace"].(string); ok && ns != "" {
+			namespace = ns
+		}
+		if platform != "" && len(validation.IsValidLabelValue(platform)) == 0 {
+			origin[dbsvc.PlatformKey] = platform
+		}
+	}
+
+	namespace, err := b.namespacePolicy.rewriteNamespace(namespace)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// only the namespaces of Cloud Foundry are created by the broker
+	var nsLabels map[string]string
+	if platform == platformCloudFoundry {
+		nsLabels = namespaceLabels(origin)
+	}
+	if err := b.checkNamespace(namespace, nsLabels); err != nil {
+		return "", nil, err
+	}
+	if platform == platformCloudFoundry {
+		if err := b.ensureNamespace(namespace, nsLabels); err != nil {
+			return "", nil, err
+		}
+	}
+	return namespace, origin, nil
+}
+
+// namespaceLabels returns the labels of a namespace created for instances of the given origin.
+func namespaceLabels(origin map[string]string) map[string]string {
+	labels := map[string]string{mu.ManagedByLabelKey: "appscode-service-broker"}
+	for k, v := range origin {
+		labels[k] = v
+	}
+	return labels
+}
+
+// ensureNamespace creates a namespace with the given labels, if it does not exist.
+func (b *Broker) ensureNamespace(name string, labels map[string]string) error {
+	_, err := b.kubeClient.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	} else if !kerr.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get namespace %s", name)
+	}
+
+	ns := &core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+	glog.Infof("Creating namespace %s...", name)
+	_, err = b.kubeClient.CoreV1().Namespaces().Create(ns)
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create namespace %s", name)
+	}
+	return nil
+}
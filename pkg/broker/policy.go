@@ -0,0 +1,138 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespacePolicy restricts the namespaces where the instances are provisioned. The namespaces are
+// matched against the policy after they are rewritten, i.e. where the instances are actually created.
+type NamespacePolicy struct {
+	// Patterns of the namespaces where instances may be provisioned, any namespace if empty
+	Allowed []string
+	// Patterns of the namespaces where instances must not be provisioned
+	Denied []string
+	// Label selector of the Namespace objects where instances may be provisioned
+	Selector string
+	// Go template of the namespace an instance is provisioned in, instead of the requested one
+	Rewrite string
+}
+
+// namespacePolicy is the parsed form of a NamespacePolicy.
+type namespacePolicy struct {
+	allowed  []string
+	denied   []string
+	selector labels.Selector
+	rewrite  *template.Template
+}
+
+// namespaceRewriteContext is available to the template that rewrites the namespaces.
+type namespaceRewriteContext struct {
+	// Namespace requested by the platform
+	Namespace string
+}
+
+func (p NamespacePolicy) parse() (*namespacePolicy, error) {
+	for _, pattern := range append(append([]string{}, p.Allowed...), p.Denied...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid namespace pattern %q", pattern)
+		}
+	}
+
+	selector, err := labels.Parse(p.Selector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid namespace selector %q", p.Selector)
+	}
+
+	policy := &namespacePolicy{
+		allowed:  p.Allowed,
+		denied:   p.Denied,
+		selector: selector,
+	}
+	if p.Rewrite != "" {
+		policy.rewrite, err = template.New("rewrite").Funcs(template.FuncMap{
+			"lower": strings.ToLower,
+		}).Parse(p.Rewrite)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid namespace rewrite %q", p.Rewrite)
+		}
+	}
+	return policy, nil
+}
+
+func matchNamespace(patterns []string, namespace string) (string, bool) {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// rewriteNamespace returns the namespace where an instance requested in the given namespace is provisioned.
+func (p *namespacePolicy) rewriteNamespace(namespace string) (string, error) {
+	if p.rewrite == nil {
+		return namespace, nil
+	}
+
+	var buf bytes.Buffer
+	if err := p.rewrite.Execute(&buf, namespaceRewriteContext{Namespace: namespace}); err != nil {
+		return "", errors.Wrapf(err, "failed to rewrite namespace %s", namespace)
+	}
+	rewritten := strings.TrimSpace(buf.String())
+	if errs := validation.IsDNS1123Label(rewritten); len(errs) > 0 {
+		return "", badRequestError("namespace %q is rewritten to an invalid namespace %q: %s", namespace, rewritten, strings.Join(errs, ", "))
+	}
+	return rewritten, nil
+}
+
+// checkNamespace returns 403 Forbidden, if instances must not be provisioned in the namespace.
+// A namespace that does not exist yet is matched against the selector with the labels it would
+// be created with, or is rejected by the selector if it is not created by the broker, i.e. nsLabels is nil.
+func (b *Broker) checkNamespace(namespace string, nsLabels map[string]string) error {
+	p := b.namespacePolicy
+
+	if pattern, found := matchNamespace(p.denied, namespace); found {
+		return forbiddenError("provisioning in namespace %s is denied by pattern %q", namespace, pattern)
+	}
+	if len(p.allowed) > 0 {
+		if _, found := matchNamespace(p.allowed, namespace); !found {
+			return forbiddenError("namespace %s is not in the allowed namespaces %s", namespace, strings.Join(p.allowed, ", "))
+		}
+	}
+
+	if p.selector.Empty() {
+		return nil
+	}
+	ns, err := b.kubeClient.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err == nil {
+		nsLabels = ns.Labels
+	} else if !kerr.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get namespace %s", namespace)
+	} else if nsLabels == nil {
+		return forbiddenError("namespace %s does not exist to match the namespace selector %q", namespace, p.selector)
+	}
+	if !p.selector.Matches(labels.Set(nsLabels)) {
+		return forbiddenError("namespace %s does not match the namespace selector %q", namespace, p.selector)
+	}
+	return nil
+}
+
+func forbiddenError(format string, args ...interface{}) error {
+	description := fmt.Sprintf(format, args...)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusForbidden,
+		Description: &description,
+	}
+}
@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// APIVersion is a version of the OSB API, as advertised by a platform in the X-Broker-API-Version header.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+var (
+	// Oldest version of the OSB API those requests are served
+	minAPIVersion = APIVersion{Major: 2, Minor: 11}
+
+	// Versions of the OSB API those introduced the behaviours of the broker,
+	// which are enabled only for the requests of a supporting version.
+	//
+	// maintenance_info of OSB API 2.15 is not gated, as the broker does not offer it: the plans of the
+	// catalog carry no maintenance_info, and go-open-service-broker-client has no maintenance_info in its
+	// catalog and requests, so the field is neither served nor read for any version.
+	originatingIdentityAPIVersion = APIVersion{Major: 2, Minor: 13}
+	fetchAPIVersion               = APIVersion{Major: 2, Minor: 14}
+	asyncBindingAPIVersion        = APIVersion{Major: 2, Minor: 14}
+)
+
+// ParseAPIVersion parses a version of the OSB API in the form <major>.<minor>.
+func ParseAPIVersion(version string) (APIVersion, error) {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	if len(parts) != 2 {
+		return APIVersion{}, fmt.Errorf("invalid OSB API version %q", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return APIVersion{}, fmt.Errorf("invalid OSB API version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return APIVersion{}, fmt.Errorf("invalid OSB API version %q", version)
+	}
+	return APIVersion{Major: major, Minor: minor}, nil
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast returns true if the version is the same as or newer than the given version.
+func (v APIVersion) AtLeast(w APIVersion) bool {
+	return v.Major > w.Major || (v.Major == w.Major && v.Minor >= w.Minor)
+}
+
+// requestAPIVersion returns the OSB API version of a request. As the version is validated
+// before the request is served, an unparsable version is taken as the oldest supported one.
+func requestAPIVersion(c *broker.RequestContext) APIVersion {
+	if c == nil || c.Request == nil {
+		return minAPIVersion
+	}
+	v, err := ParseAPIVersion(c.Request.Header.Get(osb.APIVersionHeader))
+	if err != nil {
+		return minAPIVersion
+	}
+	return v
+}
+
+// requireAPIVersion returns 412 Precondition Failed, if the request is of an older version than the given one.
+func requireAPIVersion(c *broker.RequestContext, version APIVersion, feature string) error {
+	if v := requestAPIVersion(c); !v.AtLeast(version) {
+		return apiVersionError(fmt.Sprintf("%s requires OSB API version %s or later, got %s", feature, version, v))
+	}
+	return nil
+}
+
+func apiVersionError(description string) error {
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusPreconditionFailed,
+		Description: &description,
+	}
+}
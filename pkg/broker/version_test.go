@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+func TestValidateBrokerAPIVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		valid   bool
+	}{
+		{version: "2.11", valid: true},
+		{version: "2.13", valid: true},
+		{version: "2.14", valid: true},
+		{version: "2.15", valid: true},
+		{version: " 2.14 ", valid: true},
+		{version: "2.10"},
+		{version: "1.14"},
+		{version: "3.0"},
+		{version: "2"},
+		{version: "2.x"},
+		{version: ""},
+	}
+
+	b := &Broker{}
+	for _, c := range cases {
+		err := b.ValidateBrokerAPIVersion(c.version)
+		if c.valid {
+			if err != nil {
+				t.Errorf("expected version %q to be valid, found %v", c.version, err)
+			}
+			continue
+		}
+		if e, ok := osb.IsHTTPError(err); !ok || e.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("expected version %q to be rejected with status code %d, found %v", c.version, http.StatusPreconditionFailed, err)
+		}
+	}
+}
+
+func TestRequireAPIVersion(t *testing.T) {
+	cases := []struct {
+		name     string
+		version  string
+		required APIVersion
+		valid    bool
+	}{
+		{name: "originating identity", version: "2.13", required: originatingIdentityAPIVersion, valid: true},
+		{name: "originating identity of an older version", version: "2.12", required: originatingIdentityAPIVersion},
+		{name: "fetching", version: "2.14", required: fetchAPIVersion, valid: true},
+		{name: "fetching of a newer version", version: "2.15", required: fetchAPIVersion, valid: true},
+		{name: "fetching of an older version", version: "2.13", required: fetchAPIVersion},
+		{name: "async binding", version: "2.14", required: asyncBindingAPIVersion, valid: true},
+		{name: "async binding of an older version", version: "2.13", required: asyncBindingAPIVersion},
+		{name: "no version", required: fetchAPIVersion},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/v2/catalog", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.version != "" {
+				r.Header.Set(osb.APIVersionHeader, c.version)
+			}
+
+			err = requireAPIVersion(&broker.RequestContext{Request: r}, c.required, "feature")
+			if c.valid {
+				if err != nil {
+					t.Errorf("expected version %q to be valid, found %v", c.version, err)
+				}
+				return
+			}
+			if e, ok := osb.IsHTTPError(err); !ok || e.StatusCode != http.StatusPreconditionFailed {
+				t.Errorf("expected error with status code %d, found %v", http.StatusPreconditionFailed, err)
+			}
+		})
+	}
+}
+
+func TestAPIVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		v, w     APIVersion
+		expected bool
+	}{
+		{v: APIVersion{2, 14}, w: APIVersion{2, 14}, expected: true},
+		{v: APIVersion{2, 15}, w: APIVersion{2, 14}, expected: true},
+		{v: APIVersion{3, 0}, w: APIVersion{2, 14}, expected: true},
+		{v: APIVersion{2, 13}, w: APIVersion{2, 14}},
+		{v: APIVersion{1, 15}, w: APIVersion{2, 14}},
+	}
+
+	for _, c := range cases {
+		if found := c.v.AtLeast(c.w); found != c.expected {
+			t.Errorf("expected %s at least %s to be %t, found %t", c.v, c.w, c.expected, found)
+		}
+	}
+}
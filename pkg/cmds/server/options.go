@@ -1,18 +1,42 @@
 package server
 
 import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/appscode/kutil/meta"
 	"github.com/appscode/service-broker/pkg/broker"
 	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
 	svcat_cs "github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/clientset/typed/servicecatalog/v1beta1"
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/pkg/apis/core"
+	kutil "kmodules.xyz/client-go"
 )
 
 type ExtraOptions struct {
-	DefaultNamespace string
-	CatalogPath      string
-	CatalogNames     []string
-	Async            bool
+	DefaultNamespace              string
+	CloudFoundryNamespaceTemplate string
+	AllowedNamespaces             []string
+	DeniedNamespaces              []string
+	NamespaceSelector             string
+	NamespaceRewrite              string
+	AuthorizeRequests             bool
+	AuditLogPath                  string
+	AuditEvents                   bool
+	SensitiveParams               []string
+	TerminationPolicies           map[string]string
+	DormantRetention              time.Duration
+	MongoDBClientImage            string
+	MySQLClientImage              string
+	PostgresClientImage           string
+	CatalogPath                   string
+	CatalogNames                  []string
+	Async                         bool
+	ProvisionTimeout              time.Duration
+	LeaseDuration                 time.Duration
+	ServiceCatalog                bool
 
 	QPS   float64
 	Burst int
@@ -20,11 +44,18 @@ type ExtraOptions struct {
 
 func NewExtraOptions() *ExtraOptions {
 	return &ExtraOptions{
-		CatalogPath:      "/etc/config/catalog",
-		Async:            false,
-		QPS:              100,
-		Burst:            100,
-		DefaultNamespace: core.NamespaceDefault,
+		CatalogPath:                   "/etc/config/catalog",
+		Async:                         false,
+		ProvisionTimeout:              kutil.ReadinessTimeout,
+		LeaseDuration:                 30 * time.Second,
+		ServiceCatalog:                true,
+		QPS:                           100,
+		Burst:                         100,
+		DefaultNamespace:              core.NamespaceDefault,
+		CloudFoundryNamespaceTemplate: broker.DefaultCloudFoundryNamespaceTemplate,
+		MongoDBClientImage:            dbsvc.DefaultMongoDBClientImage,
+		MySQLClientImage:              dbsvc.DefaultMySQLClientImage,
+		PostgresClientImage:           dbsvc.DefaultPostgresClientImage,
 	}
 }
 
@@ -33,11 +64,49 @@ func (s *ExtraOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringSliceVar(&s.CatalogNames, "catalog-names", s.CatalogNames,
 		"List of catalog those can be run by this service-broker, comma separated.")
 	fs.BoolVar(&s.Async, "async", s.Async, "Indicates whether the broker is handling the requests asynchronously.")
+	fs.DurationVar(&s.ProvisionTimeout, "provision-timeout", s.ProvisionTimeout, "The maximum time a synchronous provisioning or update waits for the database to be ready. Set to 0 along with --async to provision and update only asynchronously.")
+	fs.DurationVar(&s.LeaseDuration, "lease-duration", s.LeaseDuration, "The duration of the leases those coordinate the operations among the replicas of the broker. Set to 0 to run a single replica without leases.")
+	fs.BoolVar(&s.ServiceCatalog, "enable-service-catalog", s.ServiceCatalog, "Indicates whether the broker is used with Kubernetes Service Catalog, to name the databases after their ServiceInstances.")
 
 	fs.Float64Var(&s.QPS, "qps", s.QPS, "The maximum QPS to the master from this client")
 	fs.IntVar(&s.Burst, "burst", s.Burst, "The maximum burst for throttle")
 
 	fs.StringVar(&s.DefaultNamespace, "defaultNamespace", s.DefaultNamespace, "The default namespace for brokers when the request doesn't specify")
+	fs.StringVar(&s.CloudFoundryNamespaceTemplate, "cf-namespace-template", s.CloudFoundryNamespaceTemplate,
+		"Go template of the namespace for the requests from Cloud Foundry. OrganizationGUID, OrganizationName, SpaceGUID and SpaceName are available to the template.")
+	fs.StringSliceVar(&s.AllowedNamespaces, "allowed-namespaces", s.AllowedNamespaces,
+		"Patterns of the namespaces where instances may be provisioned, e.g. team-*. Instances may be provisioned in any namespace if empty.")
+	fs.StringSliceVar(&s.DeniedNamespaces, "denied-namespaces", s.DeniedNamespaces, "Patterns of the namespaces where instances must not be provisioned, e.g. kube-*")
+	fs.StringVar(&s.NamespaceSelector, "namespace-selector", s.NamespaceSelector, "Label selector of the namespaces where instances may be provisioned")
+	fs.StringVar(&s.NamespaceRewrite, "namespace-rewrite", s.NamespaceRewrite,
+		"Go template of the namespace where instances are provisioned instead of the requested one, e.g. {{ .Namespace }}-data. The policy of namespaces applies to the rewritten namespace.")
+
+	fs.BoolVar(&s.AuthorizeRequests, "authorize-originating-identity", s.AuthorizeRequests,
+		"If true, the Kubernetes users in the originating identity of the requests are authorized to perform them through SubjectAccessReviews. Requests without the originating identity of a Kubernetes user are rejected.")
+
+	fs.StringVar(&s.AuditLogPath, "broker-audit-log-path", s.AuditLogPath, "If set, all OSB calls are logged to this file as JSON lines. '-' means standard out.")
+	fs.BoolVar(&s.AuditEvents, "broker-audit-events", s.AuditEvents, "If true, the OSB calls those change the instances are recorded as Events on their KubeDB objects.")
+	fs.StringSliceVar(&s.SensitiveParams, "sensitive-params", s.SensitiveParams,
+		"Paths of the provision parameters in the dot notation those are kept in a Secret instead of the provision info, e.g. spec.init.scriptSource. * matches any field. Passwords, tokens, credentials and other known secret fields are always kept in the Secret.")
+	fs.StringToStringVar(&s.TerminationPolicies, "plan-termination-policies", s.TerminationPolicies,
+		"Termination policies of the instances by the ids of their plans, e.g. <plan-id>=Pause, unless the provision parameters set spec.terminationPolicy. One of Pause, Delete, WipeOut or DoNotTerminate.")
+	fs.DurationVar(&s.DormantRetention, "dormant-retention", s.DormantRetention,
+		"The period the instances those are paused on deprovisioning are retained for, before their DormantDatabases are wiped out. Set to 0 to retain them until they are deleted manually.")
+	fs.StringVar(&s.MongoDBClientImage, "mongodb-client-image", s.MongoDBClientImage, "The image of the mongo shell, the users of the MongoDB bindings are managed with")
+	fs.StringVar(&s.MySQLClientImage, "mysql-client-image", s.MySQLClientImage, "The image of the mysql client, the users of the MySQL bindings are managed with")
+	fs.StringVar(&s.PostgresClientImage, "postgres-client-image", s.PostgresClientImage, "The image of psql, the users of the PostgreSQL bindings are managed with")
+}
+
+func (s *ExtraOptions) Validate() []error {
+	var errs []error
+	if s.ProvisionTimeout < 0 {
+		errs = append(errs, errors.New("--provision-timeout must not be negative"))
+	}
+	// a synchronous provisioning would wait for the database forever
+	if s.ProvisionTimeout == 0 && !s.Async {
+		errs = append(errs, errors.New("--provision-timeout=0 requires --async"))
+	}
+	return errs
 }
 
 func (s *ExtraOptions) ApplyTo(cfg *broker.Config) error {
@@ -49,11 +118,40 @@ func (s *ExtraOptions) ApplyTo(cfg *broker.Config) error {
 	cfg.CatalogPath = s.CatalogPath
 	cfg.CatalogNames = s.CatalogNames
 	cfg.Async = s.Async
+	cfg.ProvisionTimeout = s.ProvisionTimeout
 	cfg.DefaultNamespace = s.DefaultNamespace
+	cfg.CloudFoundryNamespaceTemplate = s.CloudFoundryNamespaceTemplate
+	cfg.AuthorizeRequests = s.AuthorizeRequests
+	cfg.AuditLogPath = s.AuditLogPath
+	cfg.AuditEvents = s.AuditEvents
+	cfg.NamespacePolicy = broker.NamespacePolicy{
+		Allowed:  s.AllowedNamespaces,
+		Denied:   s.DeniedNamespaces,
+		Selector: s.NamespaceSelector,
+		Rewrite:  s.NamespaceRewrite,
+	}
+	cfg.Namespace = meta.Namespace()
+	cfg.LeaseDuration = s.LeaseDuration
+	// the hostname of a pod is its name
+	if cfg.Identity, err = os.Hostname(); err != nil {
+		return err
+	}
 
+	if cfg.KubeClient, err = kubernetes.NewForConfig(cfg.ClientConfig); err != nil {
+		return err
+	}
 	cfg.DBClient = dbsvc.NewClient(cfg.ClientConfig)
-	if cfg.SvcCatClient, err = svcat_cs.NewForConfig(cfg.ClientConfig); err != nil {
+	cfg.DBClient.SetCatalog(s.CatalogPath, s.CatalogNames)
+	cfg.DBClient.SetSensitiveParams(s.SensitiveParams)
+	if err := cfg.DBClient.SetTerminationPolicies(s.TerminationPolicies); err != nil {
 		return err
 	}
+	cfg.DBClient.SetDormantRetention(s.DormantRetention)
+	cfg.DBClient.SetClientImages(s.MongoDBClientImage, s.MySQLClientImage, s.PostgresClientImage)
+	if s.ServiceCatalog {
+		if cfg.SvcCatClient, err = svcat_cs.NewForConfig(cfg.ClientConfig); err != nil {
+			return err
+		}
+	}
 	return nil
 }
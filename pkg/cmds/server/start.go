@@ -10,6 +10,7 @@ import (
 	"github.com/appscode/service-broker/pkg/server"
 	"github.com/spf13/pflag"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
 	"kmodules.xyz/client-go/tools/clientcmd"
@@ -49,7 +50,7 @@ func (o BrokerServerOptions) AddFlags(fs *pflag.FlagSet) {
 }
 
 func (o BrokerServerOptions) Validate(args []string) error {
-	return nil
+	return utilerrors.NewAggregate(o.ExtraOptions.Validate())
 }
 
 func (o *BrokerServerOptions) Complete() error {
@@ -2,17 +2,25 @@ package kubedb
 
 import (
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"time"
 
 	"github.com/golang/glog"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	yaml "gopkg.in/yaml.v2"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	kutil "kmodules.xyz/client-go"
+	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 	appcat_cs "kmodules.xyz/custom-resources/client/clientset/versioned/typed/appcatalog/v1alpha1"
 	appcat_util "kmodules.xyz/custom-resources/client/clientset/versioned/typed/appcatalog/v1alpha1/util"
 )
@@ -20,14 +28,32 @@ import (
 type Client struct {
 	kubeClient kubernetes.Interface
 	appClient  appcat_cs.AppcatalogV1alpha1Interface
+	extClient  cs.KubedbV1alpha1Interface
 
 	serviceProviders map[string]Provider
+	// Caches the DormantDatabases, those are left behind by the deleted KubeDB objects
+	dormantInformer cache.SharedIndexInformer
+	// Separates the sensitive params of the instances
+	redactor *ParamRedactor
+	// Termination policies of the instances by the ids of their plans
+	terminationPolicies map[string]api.TerminationPolicy
+	// Period the paused instances are retained for, zero to retain them forever
+	dormantRetention time.Duration
+	// Lock of the collection of the DormantDatabases among the replicas of the broker, nil for a single replica
+	dormantGCLock func() (func(), bool, error)
+	// Catalogs the plans of the services are read from
+	catalogPath  string
+	catalogNames []string
+	// Client images of the databases by the ids of their services
+	clientImages map[string]string
 }
 
 func NewClient(config *rest.Config) *Client {
+	extClient := cs.NewForConfigOrDie(config)
 	return &Client{
 		kubeClient: kubernetes.NewForConfigOrDie(config),
 		appClient:  appcat_cs.NewForConfigOrDie(config),
+		extClient:  extClient,
 		serviceProviders: map[string]Provider{
 			KubeDBServiceMySQL:         NewMySQLProvider(config),
 			KubeDBServicePostgreSQL:    NewPostgreSQLProvider(config),
@@ -36,24 +62,72 @@ func NewClient(config *rest.Config) *Client {
 			KubeDBServiceRedis:         NewRedisProvider(config),
 			KubeDBServiceMemcached:     NewMemcachedProvider(config),
 		},
+		dormantInformer: newDormantInformer(extClient),
+		redactor:        NewParamRedactor(DefaultSensitiveFields, nil),
+		clientImages: map[string]string{
+			KubeDBServiceMongoDB:    DefaultMongoDBClientImage,
+			KubeDBServiceMySQL:      DefaultMySQLClientImage,
+			KubeDBServicePostgreSQL: DefaultPostgresClientImage,
+		},
+	}
+}
+
+// HasSynced reports whether the caches of the KubeDB objects are synced. The instances are looked up in
+// the caches only, so no request is served before.
+func (c *Client) HasSynced() bool {
+	for _, provider := range c.serviceProviders {
+		if !provider.Informer().HasSynced() {
+			return false
+		}
 	}
+	return c.dormantInformer.HasSynced()
 }
 
-func (c *Client) GetCatalog(catalogPath string, catalogNames ...string) ([]osb.Service, error) {
+// Run starts the informers of the providers and waits for their caches to be synced.
+func (c *Client) Run(stopCh <-chan struct{}) error {
+	var synced []cache.InformerSynced
+	for _, provider := range c.serviceProviders {
+		informer := provider.Informer()
+		go informer.Run(stopCh)
+		synced = append(synced, informer.HasSynced)
+	}
+	go c.dormantInformer.Run(stopCh)
+	synced = append(synced, c.dormantInformer.HasSynced)
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return errors.New("failed to sync the caches of the KubeDB objects")
+	}
+	glog.Infoln("Caches of the KubeDB objects are synced")
+
+	c.runDormantGC(stopCh)
+	return nil
+}
+
+// Service is a service offering of the catalog. It extends osb.Service
+// with the fields those are not supported by the OSB client library.
+type Service struct {
+	osb.Service `yaml:",inline"`
+
+	// InstancesRetrievable represents whether fetching a service instance via a GET on
+	// the instance resource's endpoint (/v2/service_instances/instance-id) is supported.
+	InstancesRetrievable bool `json:"instances_retrievable,omitempty"`
+}
+
+func (c *Client) GetCatalog(catalogPath string, catalogNames ...string) ([]Service, error) {
 	glog.Infoln("Listing services for catalog...")
 
 	names := sets.NewString(catalogNames...)
 
-	var services []osb.Service
+	var services []Service
 	for _, provider := range c.serviceProviders {
 		catalog, serviceName := provider.Metadata()
 		if names.Has(catalog) {
-			out, err := ioutil.ReadFile(filepath.Join(catalogPath, catalog, fmt.Sprintf("%s.yaml", serviceName)))
+			out, err := readCatalog(catalogPath, catalog, serviceName)
 			if err != nil {
 				return nil, err
 			}
 
-			service := osb.Service{}
+			service := Service{}
 			if err = yaml.Unmarshal(out, &service); err != nil {
 				return nil, err
 			}
@@ -65,35 +139,281 @@ func (c *Client) GetCatalog(catalogPath string, catalogNames ...string) ([]osb.S
 	return services, nil
 }
 
+// Resource returns the resource of the KubeDB objects of a service.
+func (c *Client) Resource(serviceID string) (schema.GroupResource, error) {
+	provider, exists := c.serviceProviders[serviceID]
+	if !exists {
+		return schema.GroupResource{}, unknownService(serviceID)
+	}
+	return provider.Resource(), nil
+}
+
+// kinds of the KubeDB objects by their resources
+var kinds = map[string]string{
+	api.ResourcePluralElasticsearch: api.ResourceKindElasticsearch,
+	api.ResourcePluralMemcached:     api.ResourceKindMemcached,
+	api.ResourcePluralMongoDB:       api.ResourceKindMongoDB,
+	api.ResourcePluralMySQL:         api.ResourceKindMySQL,
+	api.ResourcePluralPostgres:      api.ResourceKindPostgres,
+	api.ResourcePluralRedis:         api.ResourceKindRedis,
+}
+
+// ObjectReference returns the reference to the KubeDB object of an instance, e.g. to record Events on it.
+// It returns nil if the service is not known.
+func (c *Client) ObjectReference(serviceID, name, namespace string) *core.ObjectReference {
+	provider, exists := c.serviceProviders[serviceID]
+	if !exists {
+		return nil
+	}
+	resource := provider.Resource()
+	return &core.ObjectReference{
+		APIVersion: api.SchemeGroupVersion.String(),
+		Kind:       kinds[resource.Resource],
+		Namespace:  namespace,
+		Name:       name,
+	}
+}
+
+// ownerReference returns the reference to the KubeDB object of an instance, that owns the other objects of the instance.
+func ownerReference(provider Provider, meta *metav1.ObjectMeta) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: api.SchemeGroupVersion.String(),
+		Kind:       kinds[provider.Resource().Resource],
+		Name:       meta.Name,
+		UID:        meta.UID,
+	}
+}
+
 func (c *Client) Provision(provisionInfo ProvisionInfo) error {
 	glog.Infof("getting provider %q", provisionInfo.ServiceID)
 
 	provider, exists := c.serviceProviders[provisionInfo.ServiceID]
 	if !exists {
-		return errors.Errorf("No %q provider found", provisionInfo.ServiceID)
+		return unknownService(provisionInfo.ServiceID)
+	}
+
+	plan, err := c.plan(provisionInfo.ServiceID, provisionInfo.PlanID)
+	if err != nil {
+		return err
 	}
+	provisionInfo.plan = plan
 
-	if err := provider.Create(provisionInfo); err != nil {
-		return errors.Wrapf(err, "failed to create %s obj %q in namespace %s",
+	if err := c.separateParams(&provisionInfo); err != nil {
+		return err
+	}
+	provisionInfo.terminationPolicy = c.terminationPolicies[provisionInfo.PlanID]
+	meta, err := provider.Create(provisionInfo)
+	if err != nil {
+		return wrapError(err, "failed to create %s obj %q in namespace %s",
 			provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace)
 	}
 
-	return nil
+	// the sensitive params are saved once the object is created, so that their Secret is owned by it
+	return c.saveSensitiveParams(provisionInfo, ownerReference(provider, meta))
 }
 
-func (c *Client) GetProvisionInfo(instanceID, serviceID string) (*ProvisionInfo, error) {
+func (c *Client) Update(provisionInfo ProvisionInfo) error {
+	provider, exists := c.serviceProviders[provisionInfo.ServiceID]
+	if !exists {
+		return unknownService(provisionInfo.ServiceID)
+	}
+
+	cur, err := c.GetProvisionInfo(provisionInfo.InstanceID, provisionInfo.ServiceID)
+	if err != nil {
+		return err
+	} else if cur == nil {
+		return gone("Instance %q not found", provisionInfo.InstanceID)
+	}
+	if provisionInfo.plan, err = c.plan(provisionInfo.ServiceID, provisionInfo.PlanID); err != nil {
+		return err
+	}
+	if cur.PlanID != provisionInfo.PlanID {
+		// a plan that is no longer offered is treated as one without a spec template
+		if provisionInfo.prevPlan, err = c.plan(cur.ServiceID, cur.PlanID); err != nil {
+			if _, ok := osb.IsHTTPError(err); !ok {
+				return err
+			}
+			provisionInfo.prevPlan = &Plan{ID: cur.PlanID}
+		}
+	}
+
+	// the recorded params are redacted, so the sensitive params are restored before they are applied
+	if err := c.restoreSensitiveParams(&provisionInfo); err != nil {
+		return err
+	}
+	if err := c.separateParams(&provisionInfo); err != nil {
+		return err
+	}
+	meta, err := provider.Update(provisionInfo)
+	if err != nil {
+		return wrapError(err, "failed to update %s obj %q in namespace %s",
+			provisionInfo.ServiceID, provisionInfo.InstanceName, provisionInfo.Namespace)
+	}
+
+	return c.saveSensitiveParams(provisionInfo, ownerReference(provider, meta))
+}
+
+// WaitForReady waits until the KubeDB object of an instance is running and its AppBinding is created,
+// so that the instance can be bound right away.
+func (c *Client) WaitForReady(serviceID, instanceName, namespace string, timeout time.Duration) error {
 	provider, exists := c.serviceProviders[serviceID]
 	if !exists {
-		return nil, errors.Errorf("No %q provider found", serviceID)
+		return unknownService(serviceID)
 	}
 
-	return provider.GetProvisionInfo(instanceID)
+	start := time.Now()
+	err := provider.WaitForReady(instanceName, namespace, timeout)
+	if err == nil {
+		err = wait.PollImmediate(kutil.RetryInterval, timeout-time.Since(start), func() (bool, error) {
+			_, err := c.appClient.AppBindings(namespace).Get(instanceName, metav1.GetOptions{})
+			return err == nil, nil
+		})
+	}
+
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("%s obj %q in namespace %s is not ready after %s", serviceID, instanceName, namespace, timeout)
+	}
+	return err
+}
+
+// WaitForUpdate waits until the operator has observed the update of the KubeDB object of an instance and
+// the object is running again. It returns an error as soon as the object has failed.
+func (c *Client) WaitForUpdate(serviceID, instanceName, namespace string, timeout time.Duration) error {
+	err := wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
+		state, description, err := c.GetStatus(serviceID, instanceName, namespace)
+		if err != nil {
+			return false, err
+		}
+		if state == osb.StateFailed {
+			return false, errors.New(description)
+		}
+		return state == osb.StateSucceeded, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("%s obj %q in namespace %s is not updated after %s", serviceID, instanceName, namespace, timeout)
+	}
+	return err
+}
+
+// GetProvisionInfo returns the provision info of an instance. The instance is looked up in the caches of all
+// the providers, starting with the provider of the service, so the service id is optional.
+// It returns nil if the instance does not exist.
+func (c *Client) GetProvisionInfo(instanceID, serviceID string) (*ProvisionInfo, error) {
+	if provider, exists := c.serviceProviders[serviceID]; exists {
+		provisionInfo, err := provider.GetProvisionInfo(instanceID)
+		if err != nil || provisionInfo != nil {
+			return provisionInfo, wrapError(err, "failed to look up instance %q", instanceID)
+		}
+	}
+	for id, provider := range c.serviceProviders {
+		if id == serviceID {
+			continue
+		}
+		provisionInfo, err := provider.GetProvisionInfo(instanceID)
+		if err != nil || provisionInfo != nil {
+			return provisionInfo, wrapError(err, "failed to look up instance %q", instanceID)
+		}
+	}
+	return nil, nil
 }
 
 func (c *Client) Bind(
-	serviceID, planID string, bindParams map[string]interface{},
+	bindingID, serviceID, planID string, bindParams map[string]interface{},
 	provisionInfo ProvisionInfo) (map[string]interface{}, error) {
 
+	// Apply additional provisioning logic for Service Catalog Enabled services
+	provider, exists := c.serviceProviders[serviceID]
+	if !exists {
+		return nil, unknownService(serviceID)
+	}
+
+	app, err := c.appClient.AppBindings(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := c.credentials(provider, app, bindParams, provisionInfo)
+	if err != nil {
+		if _, ok := osb.IsHTTPError(err); ok {
+			return nil, err
+		}
+		return nil, wrapError(err, "unable to bind instance for %q/%q", serviceID, planID)
+	}
+
+	role, err := bindingRole(bindParams)
+	if err != nil {
+		return nil, err
+	}
+	up, withUser := provider.(userProvider)
+	secret, err := c.ensureBindingSecret(app, provisionInfo.InstanceID, bindingID, role, withUser)
+	if err != nil {
+		if _, ok := osb.IsHTTPError(err); ok {
+			return nil, err
+		}
+		return nil, wrapError(err, "unable to create secret of binding %q", bindingID)
+	}
+
+	// hand out the user of the binding instead of the admin user
+	if withUser {
+		err = c.createUserJob(c.clientImages[serviceID], app, provisionInfo.InstanceID, bindingID, "create", up.createUserScript(role))
+		if err == nil {
+			err = c.waitForUserJob(secret.Namespace, secret.Name+"-create", userJobTimeout)
+		}
+		if err != nil {
+			return nil, wrapError(err, "unable to create user of binding %q", bindingID)
+		}
+		creds.Username = string(secret.Data[appcat.KeyUsername])
+		creds.Password = string(secret.Data[appcat.KeyPassword])
+	}
+
+	return creds.ToMap()
+}
+
+// GetBinding returns the credentials and the parameters of an existing binding.
+// It returns 404 Not Found, if the binding does not exist or belongs to another instance.
+func (c *Client) GetBinding(bindingID string, provisionInfo ProvisionInfo) (map[string]interface{}, map[string]interface{}, error) {
+	provider, exists := c.serviceProviders[provisionInfo.ServiceID]
+	if !exists {
+		return nil, nil, unknownService(provisionInfo.ServiceID)
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets(provisionInfo.Namespace).Get(bindingName(bindingID), metav1.GetOptions{})
+	if kerr.IsNotFound(err) || err == nil && !bindingOf(secret, provisionInfo.InstanceID) {
+		return nil, nil, notFound("Binding %q not found", bindingID)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	bindParams := map[string]interface{}{
+		bindingRoleParam: string(secret.Data[keyBindingRole]),
+	}
+
+	app, err := c.appClient.AppBindings(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creds, err := c.credentials(provider, app, bindParams, provisionInfo)
+	if err != nil {
+		return nil, nil, wrapError(err, "unable to get credentials of binding %q", bindingID)
+	}
+	if len(secret.Data[appcat.KeyUsername]) > 0 {
+		creds.Username = string(secret.Data[appcat.KeyUsername])
+		creds.Password = string(secret.Data[appcat.KeyPassword])
+	}
+
+	credentials, err := creds.ToMap()
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials, bindParams, nil
+}
+
+// credentials returns the credentials of the admin user from the AppBinding of an instance.
+func (c *Client) credentials(
+	provider Provider, app *appcat.AppBinding,
+	bindParams map[string]interface{}, provisionInfo ProvisionInfo) (*Credentials, error) {
+
 	params := make(map[string]interface{}, len(bindParams)+len(provisionInfo.Params))
 	for k, v := range provisionInfo.Params {
 		params[k] = v
@@ -105,11 +425,6 @@ func (c *Client) Bind(
 		params[k] = v
 	}
 
-	app, err := c.appClient.AppBindings(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
-
 	data := make(map[string]interface{})
 	if app.Spec.Secret != nil {
 		secret, err := c.kubeClient.CoreV1().Secrets(provisionInfo.Namespace).Get(app.Spec.Secret.Name, metav1.GetOptions{})
@@ -128,31 +443,104 @@ func (c *Client) Bind(
 		data["root.pem"] = app.Spec.ClientConfig.CABundle
 	}
 
-	// Apply additional provisioning logic for Service Catalog Enabled services
-	provider, exists := c.serviceProviders[serviceID]
+	return provider.Bind(app, params, data)
+}
+
+// Unbind drops the user of a binding from the database and deletes the objects of the binding.
+// It returns 410 Gone, if the binding does not exist or belongs to another instance.
+func (c *Client) Unbind(bindingID string, provisionInfo ProvisionInfo) error {
+	provider, exists := c.serviceProviders[provisionInfo.ServiceID]
 	if !exists {
-		return nil, errors.Errorf("No %q provider found", serviceID)
+		return unknownService(provisionInfo.ServiceID)
 	}
 
-	creds, err := provider.Bind(app, params, data)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to bind instance for %q/%q", serviceID, planID)
+	name := bindingName(bindingID)
+	secret, err := c.kubeClient.CoreV1().Secrets(provisionInfo.Namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) || err == nil && !bindingOf(secret, provisionInfo.InstanceID) {
+		return gone("Binding %q not found", bindingID)
+	} else if err != nil {
+		return err
 	}
 
-	return creds.ToMap()
+	if up, ok := provider.(userProvider); ok && len(secret.Data[appcat.KeyUsername]) > 0 {
+		app, err := c.appClient.AppBindings(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		err = c.createUserJob(c.clientImages[provisionInfo.ServiceID], app, provisionInfo.InstanceID, bindingID, "drop", up.dropUserScript())
+		if err == nil {
+			err = c.waitForUserJob(secret.Namespace, name+"-drop", userJobTimeout)
+		}
+		if err != nil {
+			return wrapError(err, "unable to drop user of binding %q", bindingID)
+		}
+	}
+
+	return wrapError(c.deleteBindingObjects(provisionInfo.Namespace, bindingID), "unable to delete binding %q", bindingID)
 }
 
-func (c *Client) Deprovision(serviceID, instanceName, namespace string) error {
+// Deprovision deletes the KubeDB object of an instance with the requested termination policy, or with its
+// own policy if none is requested, and returns the policy it is deleted with. The data of a paused instance
+// is kept in its DormantDatabase along with its sensitive params. Otherwise, the DormantDatabase that may be
+// left behind and the sensitive params are deleted too. The resources those are already deleted are skipped,
+// so that a half-deleted instance can be deprovisioned again.
+func (c *Client) Deprovision(serviceID, instanceName, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
 	glog.Infof("getting provider for %q", serviceID)
 
 	provider, exists := c.serviceProviders[serviceID]
 	if !exists {
-		return errors.Errorf("No %q provider found", serviceID)
+		return "", unknownService(serviceID)
 	}
 
-	if err := provider.Delete(instanceName, namespace); err != nil {
-		return errors.Wrapf(err, "failed to delete %s obj %q from namespace %q", serviceID, instanceName, namespace)
+	// the sensitive params are released from the object first, as a paused instance keeps them for its resumption
+	if err := c.orphanParamsSecret(instanceName, namespace); err != nil {
+		return "", err
+	}
+	deletion, err := provider.Delete(instanceName, namespace, policy)
+	if err != nil {
+		return "", wrapError(err, "failed to delete %s obj %q from namespace %q", serviceID, instanceName, namespace)
+	}
+	if deletion == api.TerminationPolicyPause {
+		return deletion, nil
+	}
+	if err := c.deleteDormantDatabase(instanceName, namespace); err != nil {
+		return "", wrapError(err, "failed to delete DormantDatabase %q from namespace %q", instanceName, namespace)
+	}
+	if err := c.deleteParamsSecret(instanceName, namespace); err != nil {
+		return "", err
 	}
 
-	return nil
+	return deletion, nil
+}
+
+// GetStatus maps the phase of the KubeDB object of an instance to the state of an OSB operation
+// along with a human readable description of the state.
+func (c *Client) GetStatus(serviceID, instanceName, namespace string) (osb.LastOperationState, string, error) {
+	provider, exists := c.serviceProviders[serviceID]
+	if !exists {
+		return "", "", unknownService(serviceID)
+	}
+
+	phase, reason, err := provider.GetStatus(instanceName, namespace)
+	if err != nil {
+		return "", "", wrapError(err, "failed to get status of %s obj %q in namespace %s", serviceID, instanceName, namespace)
+	}
+
+	_, serviceName := provider.Metadata()
+	switch phase {
+	case api.DatabasePhaseRunning:
+		return osb.StateSucceeded, fmt.Sprintf("%s %s/%s is running", serviceName, namespace, instanceName), nil
+	case api.DatabasePhaseFailed:
+		description := fmt.Sprintf("%s %s/%s has failed", serviceName, namespace, instanceName)
+		if reason != "" {
+			description = fmt.Sprintf("%s: %s", description, reason)
+		}
+		return osb.StateFailed, description, nil
+	case "":
+		return osb.StateInProgress, fmt.Sprintf("%s %s/%s is being reconciled", serviceName, namespace, instanceName), nil
+	case api.DatabasePhaseInitializing:
+		return osb.StateInProgress, fmt.Sprintf("%s %s/%s is initializing", serviceName, namespace, instanceName), nil
+	default:
+		return osb.StateInProgress, fmt.Sprintf("%s %s/%s is being created", serviceName, namespace, instanceName), nil
+	}
 }
@@ -4,9 +4,22 @@ const (
 	// Key to set instance id
 	InstanceKey = "servicecatalog.k8s.io/instance-id"
 
+	// Key to set binding id
+	BindingKey = "servicecatalog.k8s.io/binding-id"
+
 	// Key to provision info
 	ProvisionInfoKey = "servicecatalog.k8s.io/provision-info"
 
+	// Keys to record the platform, and the Cloud Foundry organization and space an instance is provisioned from
+	PlatformKey                 = "servicecatalog.k8s.io/platform"
+	CloudFoundryOrganizationKey = "servicecatalog.k8s.io/cf-organization-guid"
+	CloudFoundrySpaceKey        = "servicecatalog.k8s.io/cf-space-guid"
+
+	// Key to record the time an instance is deprovisioned at, while its data is kept in a DormantDatabase
+	DeprovisionedAtKey = "servicecatalog.k8s.io/deprovisioned-at"
+	// Key to record the termination policy an instance had before it is paused, which is restored on resuming it
+	PausedTerminationPolicyKey = "servicecatalog.k8s.io/paused-termination-policy"
+
 	// The file path for checking the namespace in which the broker server is running
 	NamespaceFilePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 
@@ -21,15 +34,16 @@ const (
 	KubeDBServicePostgreSQL    = "2010d83f-d908-4d9f-879c-ce8f5f527f2a"
 	KubeDBServiceRedis         = "ccfd1c81-e59f-4875-a39f-75ba55320ce0"
 
-	// Versions used in demo plans of different databases
-	demoElasticSearchVersion = "6.3-v1"
-	demoMemcachedVersion     = "1.5.4-v1"
-	demoMongoDBVersion       = "3.6-v2"
-	demoMySQLVersion         = "8.0.14"
-	demoPostgresVersion      = "11.1-v1"
-	demoRedisVersion         = "4.0.11"
+	// Default client images used to manage the users of bindings
+	DefaultMongoDBClientImage  = "mongo:3.6"
+	DefaultMySQLClientImage    = "mysql:8.0"
+	DefaultPostgresClientImage = "postgres:11-alpine"
+
+	// Database of the applications in a MySQL or MongoDB instance, the users of the bindings are granted privileges on it only
+	appDatabase = "app"
 
-	// Name of the plans of under different services
+	// Ids of the plans those are offered in the default catalog, the databases of the plans are
+	// defined by the spec templates of the plans in the catalog
 	PlanElasticSearchDemo        = "c4e99557-3a81-452e-b9cf-660f01c155c0"
 	PlanElasticSearchClusterDemo = "2f05622b-724d-458f-abc8-f223b1afa0b9"
 	PlanElasticSearch            = "6fa212e2-e043-4ae9-91c2-8e5c4403d894"
@@ -0,0 +1,177 @@
+package kubedb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newDormantInformer returns an informer of the DormantDatabases, indexed by the id of the instance
+// of their origin. The DormantDatabases do not carry the labels of their origin, so all of them are cached.
+func newDormantInformer(extClient cs.KubedbV1alpha1Interface) cache.SharedIndexInformer {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return extClient.DormantDatabases(core.NamespaceAll).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return extClient.DormantDatabases(core.NamespaceAll).Watch(options)
+		},
+	}
+	return cache.NewSharedIndexInformer(lw, &api.DormantDatabase{}, 0, cache.Indexers{
+		instanceIndex: indexDormantByInstance,
+	})
+}
+
+func indexDormantByInstance(obj interface{}) ([]string, error) {
+	dd, ok := obj.(*api.DormantDatabase)
+	if !ok {
+		return nil, errors.Errorf("expected DormantDatabase, found %T", obj)
+	}
+	if id, found := dd.Spec.Origin.Labels[InstanceKey]; found {
+		return []string{id}, nil
+	}
+	return nil, nil
+}
+
+// dormantDatabases returns the DormantDatabases of an instance from the cache. The broker serves requests
+// only once the cache is synced, so a DormantDatabase that is not found in the cache does not exist.
+func (c *Client) dormantDatabases(instanceID string) ([]*api.DormantDatabase, error) {
+	items, err := c.dormantInformer.GetIndexer().ByIndex(instanceIndex, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbs []*api.DormantDatabase
+	for _, item := range items {
+		dbs = append(dbs, item.(*api.DormantDatabase))
+	}
+	return dbs, nil
+}
+
+// GetDormantProvisionInfo returns the provision info of an instance whose KubeDB object is deleted,
+// but whose DormantDatabase is left behind. Instances those are paused on deprovisioning are not
+// considered half-deleted. It returns nil if there is no such DormantDatabase.
+func (c *Client) GetDormantProvisionInfo(instanceID string) (*ProvisionInfo, error) {
+	dbs, err := c.dormantDatabases(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var left []*api.DormantDatabase
+	for _, dd := range dbs {
+		if _, paused := dd.Spec.Origin.Annotations[DeprovisionedAtKey]; !paused {
+			left = append(left, dd)
+		}
+	}
+
+	switch len(left) {
+	case 0:
+		return nil, nil
+	case 1:
+		return provisionInfoFromObjectMeta(&left[0].Spec.Origin.ObjectMeta)
+	default:
+		var names []string
+		for _, dd := range left {
+			names = append(names, fmt.Sprintf("%s/%s", dd.Namespace, dd.Name))
+		}
+		return nil, errors.Errorf("%d DormantDatabases with instance id %s found: %s",
+			len(left), instanceID, strings.Join(names, ", "))
+	}
+}
+
+// deleteDormantDatabase wipes out the data of the DormantDatabase of an instance and deletes it.
+// It is a no-op, if there is no DormantDatabase.
+func (c *Client) deleteDormantDatabase(name, namespace string) error {
+	dd, err := c.extClient.DormantDatabases(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	glog.Infof("Deleting DormantDatabase %q from namespace %q...", name, namespace)
+	if !dd.Spec.WipeOut {
+		_, _, err = util.PatchDormantDatabase(c.extClient, dd, func(in *api.DormantDatabase) *api.DormantDatabase {
+			in.Spec.WipeOut = true
+			return in
+		})
+		if kerr.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := c.extClient.DormantDatabases(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// RemainingResources returns the resources of an instance deleted with the given termination policy
+// those are not deleted yet, e.g. the KubeDB object, its DormantDatabase and the volumes of the database.
+// It returns none, once the instance is completely deleted, or its KubeDB object is deleted if it is paused.
+func (c *Client) RemainingResources(serviceID, instanceName, namespace string, policy api.TerminationPolicy) ([]string, error) {
+	provider, exists := c.serviceProviders[serviceID]
+	if !exists {
+		return nil, unknownService(serviceID)
+	}
+	resource := provider.Resource()
+
+	var remaining []string
+	found := func(err error, format string, args ...interface{}) error {
+		if kerr.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return wrapError(err, "failed to look up the resources of %s/%s", namespace, instanceName)
+		}
+		remaining = append(remaining, fmt.Sprintf(format, args...))
+		return nil
+	}
+
+	_, _, err := provider.GetStatus(instanceName, namespace)
+	if err := found(err, "%s %s/%s", resource.Resource, namespace, instanceName); err != nil {
+		return nil, err
+	}
+	if policy == api.TerminationPolicyPause {
+		// the data of a paused instance is kept
+		return remaining, nil
+	}
+	_, err = c.extClient.DormantDatabases(namespace).Get(instanceName, metav1.GetOptions{})
+	if err := found(err, "dormantdatabases %s/%s", namespace, instanceName); err != nil {
+		return nil, err
+	}
+	_, err = c.appClient.AppBindings(namespace).Get(instanceName, metav1.GetOptions{})
+	if err := found(err, "appbindings %s/%s", namespace, instanceName); err != nil {
+		return nil, err
+	}
+	_, err = c.kubeClient.CoreV1().Secrets(namespace).Get(paramsSecretName(instanceName), metav1.GetOptions{})
+	if err := found(err, "secrets %s/%s", namespace, paramsSecretName(instanceName)); err != nil {
+		return nil, err
+	}
+
+	pvcs, err := c.kubeClient.CoreV1().PersistentVolumeClaims(namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set{
+			api.LabelDatabaseKind: kinds[resource.Resource],
+			api.LabelDatabaseName: instanceName,
+		}.String(),
+	})
+	if err != nil {
+		return nil, wrapError(err, "failed to look up the resources of %s/%s", namespace, instanceName)
+	}
+	if n := len(pvcs.Items); n > 0 {
+		remaining = append(remaining, fmt.Sprintf("%d persistentvolumeclaims", n))
+	}
+	return remaining, nil
+}
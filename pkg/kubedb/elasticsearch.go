@@ -1,62 +1,39 @@
 package kubedb
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	jsonTypes "github.com/appscode/go/encoding/json/types"
-	"github.com/appscode/go/types"
 	"github.com/golang/glog"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 )
 
 type ElasticsearchProvider struct {
 	extClient cs.KubedbV1alpha1Interface
+	informer  *instanceInformer
 }
 
 func NewElasticsearchProvider(config *rest.Config) Provider {
+	extClient := cs.NewForConfigOrDie(config)
 	return &ElasticsearchProvider{
-		extClient: cs.NewForConfigOrDie(config),
-	}
-}
-
-func demoElasticsearchSpec() api.ElasticsearchSpec {
-	return api.ElasticsearchSpec{
-		Version:           jsonTypes.StrYo(demoElasticSearchVersion),
-		Replicas:          types.Int32P(1),
-		EnableSSL:         true,
-		StorageType:       api.StorageTypeEphemeral,
-		TerminationPolicy: api.TerminationPolicyWipeOut,
-	}
-}
-
-func demoElasticsearchClusterSpec() api.ElasticsearchSpec {
-	return api.ElasticsearchSpec{
-		Version:           jsonTypes.StrYo(demoElasticSearchVersion),
-		EnableSSL:         true,
-		StorageType:       api.StorageTypeEphemeral,
-		TerminationPolicy: api.TerminationPolicyWipeOut,
-		Topology: &api.ElasticsearchClusterTopology{
-			Master: api.ElasticsearchNode{
-				Prefix:   "master",
-				Replicas: types.Int32P(1),
+		extClient: extClient,
+		informer: newInstanceInformer(&api.Elasticsearch{},
+			func(options metav1.ListOptions) (runtime.Object, error) {
+				return extClient.Elasticsearches(corev1.NamespaceAll).List(options)
 			},
-			Data: api.ElasticsearchNode{
-				Prefix:   "data",
-				Replicas: types.Int32P(2),
-			},
-			Client: api.ElasticsearchNode{
-				Prefix:   "client",
-				Replicas: types.Int32P(1),
-			},
-		},
+			func(options metav1.ListOptions) (watch.Interface, error) {
+				return extClient.Elasticsearches(corev1.NamespaceAll).Watch(options)
+			}),
 	}
 }
 
@@ -64,54 +41,91 @@ func (p ElasticsearchProvider) Metadata() (string, string) {
 	return "kubedb", "elasticsearch"
 }
 
-func (p ElasticsearchProvider) Create(provisionInfo ProvisionInfo) error {
+func (p ElasticsearchProvider) Resource() schema.GroupResource {
+	return api.Resource(api.ResourcePluralElasticsearch)
+}
+
+func (p ElasticsearchProvider) Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
 	var es api.Elasticsearch
 
 	// set metadata from provision info
 	if err := provisionInfo.applyToMetadata(&es.ObjectMeta); err != nil {
-		return err
+		return nil, err
 	}
 
-	// set postgres spec
-	switch provisionInfo.PlanID {
-	case PlanElasticSearchDemo:
-		es.Spec = demoElasticsearchSpec()
-	case PlanElasticSearchClusterDemo:
-		es.Spec = demoElasticsearchClusterSpec()
-	case PlanElasticSearch:
-		if err := provisionInfo.applyToSpec(&es.Spec); err != nil {
-			return err
-		}
+	// set spec from the plan and the params
+	if err := provisionInfo.applyToSpec(&es.Spec); err != nil {
+		return nil, err
+	}
+	provisionInfo.applyTerminationPolicy(&es.Spec.TerminationPolicy)
+	if origin := provisionInfo.origin; origin != nil && origin.Spec.Elasticsearch != nil {
+		// KubeDB resumes the DormantDatabase with the spec of its origin
+		es.Spec = *origin.Spec.Elasticsearch
+		provisionInfo.resumeTerminationPolicy(&es.Spec.TerminationPolicy)
 	}
 
 	glog.Infof("Creating elasticsearch obj %q in namespace %q...", es.Name, es.Namespace)
-	_, err := p.extClient.Elasticsearches(es.Namespace).Create(&es)
-
-	return err
+	out, err := p.extClient.Elasticsearches(es.Namespace).Create(&es)
+	if err != nil {
+		return nil, err
+	}
+	return &out.ObjectMeta, nil
 }
 
-func (p ElasticsearchProvider) Delete(name, namespace string) error {
-	glog.Infof("Deleting elasticsearch obj %q from namespace %q...", name, namespace)
+func (p ElasticsearchProvider) Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
+	es, err := p.extClient.Elasticsearches(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	es, err := p.extClient.Elasticsearches(namespace).Get(name, metav1.GetOptions{})
+	var spec api.ElasticsearchSpec
+	if err := provisionInfo.mergeSpecUpdate(es.Spec, &spec); err != nil {
+		return nil, err
+	}
+
+	meta := es.ObjectMeta.DeepCopy()
+	if err := provisionInfo.applyToMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Updating elasticsearch obj %q in namespace %q...", es.Name, es.Namespace)
+	err = patchElasticsearch(p.extClient, es, func(in *api.Elasticsearch) *api.Elasticsearch {
+		in.Labels = meta.Labels
+		in.Annotations = meta.Annotations
+		in.Spec = spec
+		return in
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &es.ObjectMeta, nil
+}
 
-	if es.Spec.TerminationPolicy != api.TerminationPolicyWipeOut {
-		if err := patchElasticsearch(p.extClient, es, func(in *api.Elasticsearch) *api.Elasticsearch {
-			in.Spec.TerminationPolicy = api.TerminationPolicyWipeOut
-			return in
-		}); err != nil {
-			return err
-		}
+func (p ElasticsearchProvider) Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
+	es, err := p.extClient.Elasticsearches(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	deletion, err := deletionPolicy(es.ObjectMeta, es.Spec.TerminationPolicy, policy)
+	if err != nil {
+		return "", err
+	}
+	if err := patchElasticsearch(p.extClient, es, func(in *api.Elasticsearch) *api.Elasticsearch {
+		prepareDeletion(&in.ObjectMeta, &in.Spec.TerminationPolicy, deletion)
+		return in
+	}); err != nil {
+		return "", err
 	}
 
-	if err := p.extClient.Elasticsearches(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-		return err
+	glog.Infof("Deleting elasticsearch obj %q from namespace %q with termination policy %s...", name, namespace, deletion)
+	if err := p.extClient.Elasticsearches(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return "", err
 	}
 
-	return nil
+	return deletion, nil
 }
 
 func (p ElasticsearchProvider) Bind(
@@ -134,6 +148,13 @@ func (p ElasticsearchProvider) Bind(
 		return nil, errors.Wrapf(err, `failed to retrieve "uri" from secret for %s %s/%s`, app.Spec.Type, app.Namespace, app.Name)
 	}
 
+	// users of elasticsearch are kept in the config of its auth plugin, so bindings can not get users
+	// of their own. Every binding gets the admin user, and the roles of the bindings are not supported.
+	if _, found := params[bindingRoleParam]; found {
+		return nil, badRequest("parameter %s is not supported by %s bindings, the bindings of elasticsearch share the admin user",
+			bindingRoleParam, app.Spec.Type)
+	}
+
 	username, ok := data["username"]
 	if !ok {
 		return nil, errors.Errorf(`"username" not found in secret keys for %s %s/%s`, app.Spec.Type, app.Namespace, app.Name)
@@ -161,24 +182,21 @@ func (p ElasticsearchProvider) Bind(
 }
 
 func (p ElasticsearchProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
-	elasticsearches, err := p.extClient.Elasticsearches(corev1.NamespaceAll).List(metav1.ListOptions{
-		LabelSelector: labels.Set{
-			InstanceKey: instanceID,
-		}.String(),
-	})
-	if err != nil || len(elasticsearches.Items) == 0 {
-		return nil, err
-	}
+	return p.informer.provisionInfo(instanceID, "Elasticsearch clusters")
+}
 
-	if len(elasticsearches.Items) > 1 {
-		var instances []string
-		for _, elasticsearch := range elasticsearches.Items {
-			instances = append(instances, fmt.Sprintf("%s/%s", elasticsearch.Namespace, elasticsearch.Name))
-		}
+func (p ElasticsearchProvider) Informer() cache.SharedIndexInformer {
+	return p.informer
+}
 
-		return nil, errors.Errorf("%d Elasticsearch clusters with instance id %s found: %s",
-			len(elasticsearches.Items), instanceID, strings.Join(instances, ", "))
+func (p ElasticsearchProvider) GetStatus(name, namespace string) (api.DatabasePhase, string, error) {
+	es, err := p.extClient.Elasticsearches(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
 	}
+	return observedPhase(es.ObjectMeta, es.Status.Phase, es.Status.ObservedGeneration), es.Status.Reason, nil
+}
 
-	return provisionInfoFromObjectMeta(elasticsearches.Items[0].ObjectMeta)
+func (p ElasticsearchProvider) WaitForReady(name, namespace string, timeout time.Duration) error {
+	return waitForElasticsearchBeReady(p.extClient, name, namespace, timeout)
 }
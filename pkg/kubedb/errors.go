@@ -0,0 +1,103 @@
+package kubedb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// Error codes of the OSB API those tell the platform how to proceed
+// ref: https://github.com/openservicebrokerapi/servicebroker/blob/master/spec.md#service-broker-errors
+const (
+	ErrorAsyncRequired    = "AsyncRequired"
+	ErrorConcurrencyError = "ConcurrencyError"
+)
+
+// The errors of the KubeDB services are osb.HTTPStatusCodeErrors, so that the platform gets a status code
+// and a description the users can act on. Errors of other types are responded with 500 Internal Server Error.
+
+func badRequest(format string, args ...interface{}) error {
+	return statusError(http.StatusBadRequest, nil, format, args...)
+}
+
+func conflict(format string, args ...interface{}) error {
+	return statusError(http.StatusConflict, nil, format, args...)
+}
+
+func gone(format string, args ...interface{}) error {
+	return statusError(http.StatusGone, nil, format, args...)
+}
+
+func notFound(format string, args ...interface{}) error {
+	return statusError(http.StatusNotFound, nil, format, args...)
+}
+
+func concurrencyError(format string, args ...interface{}) error {
+	code := ErrorConcurrencyError
+	return statusError(http.StatusUnprocessableEntity, &code, format, args...)
+}
+
+func unavailable(format string, args ...interface{}) error {
+	return statusError(http.StatusServiceUnavailable, nil, format, args...)
+}
+
+func statusError(statusCode int, errorMessage *string, format string, args ...interface{}) error {
+	description := fmt.Sprintf(format, args...)
+	return osb.HTTPStatusCodeError{
+		StatusCode:   statusCode,
+		ErrorMessage: errorMessage,
+		Description:  &description,
+	}
+}
+
+func unknownService(serviceID string) error {
+	return badRequest("service %q is not offered by the broker", serviceID)
+}
+
+func unknownPlan(serviceID, planID string) error {
+	return badRequest("plan %q is not offered by service %q", planID, serviceID)
+}
+
+// wrapError annotates an error with the context of the failed operation, and turns the errors of the
+// API server into the OSB errors of the same meaning. The errors of the API server those are not
+// caused by the request, e.g. an outage, are responded with 503 Service Unavailable, so that the
+// platform retries the request. Not found errors are left as they are, as their meaning depends on
+// the operation.
+func wrapError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := osb.IsHTTPError(err); ok {
+		return err
+	}
+
+	context := fmt.Sprintf(format, args...)
+	cause := errors.Cause(err)
+	switch {
+	case kerr.IsAlreadyExists(cause):
+		return conflict("%s: %v", context, cause)
+	case kerr.IsConflict(cause):
+		return concurrencyError("%s: the object is modified concurrently, try again: %v", context, cause)
+	case kerr.IsInvalid(cause), kerr.IsBadRequest(cause):
+		return badRequest("%s: %v", context, cause)
+	case kerr.IsServerTimeout(cause), kerr.IsTimeout(cause), kerr.IsServiceUnavailable(cause),
+		kerr.IsTooManyRequests(cause), kerr.IsInternalError(cause), kerr.IsUnexpectedServerError(cause):
+		return unavailable("%s: the Kubernetes API server is unavailable, try again later: %v", context, cause)
+	case isNetworkError(cause):
+		return unavailable("%s: the Kubernetes API server is unreachable, try again later: %v", context, cause)
+	}
+	return errors.Wrap(err, context)
+}
+
+func isNetworkError(err error) bool {
+	if utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -0,0 +1,80 @@
+package kubedb
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestWrapError(t *testing.T) {
+	resource := schema.GroupResource{Group: "kubedb.com", Resource: "postgreses"}
+	kind := schema.GroupKind{Group: "kubedb.com", Kind: "Postgres"}
+
+	cases := []struct {
+		name string
+		err  error
+		// expected status code of the error, zero if it is not turned into an OSB error
+		code int
+		// expected error code of the OSB error
+		message string
+	}{
+		{name: "OSB error", err: gone("gone"), code: http.StatusGone},
+		{name: "already exists", err: kerr.NewAlreadyExists(resource, "db"), code: http.StatusConflict},
+		{
+			name:    "conflict",
+			err:     kerr.NewConflict(resource, "db", errors.New("modified")),
+			code:    http.StatusUnprocessableEntity,
+			message: ErrorConcurrencyError,
+		},
+		{name: "invalid", err: kerr.NewInvalid(kind, "db", field.ErrorList{field.Required(field.NewPath("spec"), "")}), code: http.StatusBadRequest},
+		{name: "bad request", err: kerr.NewBadRequest("bad"), code: http.StatusBadRequest},
+		{name: "server timeout", err: kerr.NewServerTimeout(resource, "create", 1), code: http.StatusServiceUnavailable},
+		{name: "timeout", err: kerr.NewTimeoutError("timeout", 1), code: http.StatusServiceUnavailable},
+		{name: "service unavailable", err: kerr.NewServiceUnavailable("unavailable"), code: http.StatusServiceUnavailable},
+		{name: "too many requests", err: kerr.NewTooManyRequests("busy", 1), code: http.StatusServiceUnavailable},
+		{name: "internal error", err: kerr.NewInternalError(errors.New("internal")), code: http.StatusServiceUnavailable},
+		{name: "network error", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, code: http.StatusServiceUnavailable},
+		{name: "EOF", err: io.EOF, code: http.StatusServiceUnavailable},
+		{name: "wrapped cause", err: errors.Wrap(kerr.NewAlreadyExists(resource, "db"), "create"), code: http.StatusConflict},
+		{name: "not found", err: kerr.NewNotFound(resource, "db")},
+		{name: "forbidden", err: kerr.NewForbidden(resource, "db", errors.New("denied"))},
+		{name: "other error", err: errors.New("failed")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := wrapError(c.err, "failed to create %q", "db")
+			if err == nil {
+				t.Fatal("expected an error, found nil")
+			}
+
+			e, ok := osb.IsHTTPError(err)
+			if c.code == 0 {
+				if ok {
+					t.Errorf("expected the error to be left as it is, found status code %d", e.StatusCode)
+				}
+				if errors.Cause(err) != c.err {
+					t.Errorf("expected the cause to be kept, found %v", errors.Cause(err))
+				}
+				return
+			}
+			if !ok || e.StatusCode != c.code {
+				t.Fatalf("expected error with status code %d, found %v", c.code, err)
+			}
+			if c.message != "" && (e.ErrorMessage == nil || *e.ErrorMessage != c.message) {
+				t.Errorf("expected error code %s, found %v", c.message, e.ErrorMessage)
+			}
+		})
+	}
+
+	if err := wrapError(nil, "failed"); err != nil {
+		t.Errorf("expected no error, found %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package kubedb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Name of the index of the KubeDB objects by the id of their instance
+const instanceIndex = "instance-id"
+
+type listFunc func(options metav1.ListOptions) (runtime.Object, error)
+
+type watchFunc func(options metav1.ListOptions) (watch.Interface, error)
+
+// instanceInformer caches the KubeDB objects of a kind those are provisioned by the broker,
+// indexed by the id of their instance, so that instances are looked up in memory.
+type instanceInformer struct {
+	cache.SharedIndexInformer
+}
+
+func newInstanceInformer(objType runtime.Object, listObjects listFunc, watchObjects watchFunc) *instanceInformer {
+	// only the objects of the broker are cached
+	selectInstances := func(options *metav1.ListOptions) {
+		options.LabelSelector = InstanceKey
+	}
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			selectInstances(&options)
+			return listObjects(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			selectInstances(&options)
+			return watchObjects(options)
+		},
+	}
+
+	return &instanceInformer{
+		SharedIndexInformer: cache.NewSharedIndexInformer(lw, objType, 0, cache.Indexers{
+			instanceIndex: indexByInstance,
+		}),
+	}
+}
+
+func indexByInstance(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	if id, found := accessor.GetLabels()[InstanceKey]; found {
+		return []string{id}, nil
+	}
+	return nil, nil
+}
+
+// objects returns the objects of an instance from the cache. The broker serves requests only once the
+// cache is synced, so an instance that is not found in the cache does not exist.
+func (i *instanceInformer) objects(instanceID string) ([]metav1.Object, error) {
+	items, err := i.GetIndexer().ByIndex(instanceIndex, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]metav1.Object, 0, len(items))
+	for _, item := range items {
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, accessor)
+	}
+	return objs, nil
+}
+
+// provisionInfo returns the provision info of an instance from its object, kind is the name of the objects used in errors.
+// It returns nil if the instance does not exist.
+func (i *instanceInformer) provisionInfo(instanceID, kind string) (*ProvisionInfo, error) {
+	objs, err := i.objects(instanceID)
+	if err != nil || len(objs) == 0 {
+		return nil, err
+	}
+
+	if len(objs) > 1 {
+		var instances []string
+		for _, obj := range objs {
+			instances = append(instances, fmt.Sprintf("%s/%s", obj.GetNamespace(), obj.GetName()))
+		}
+
+		return nil, errors.Errorf("%d %s with instance id %s found: %s",
+			len(objs), kind, instanceID, strings.Join(instances, ", "))
+	}
+	return provisionInfoFromObjectMeta(objs[0])
+}
@@ -1,53 +1,39 @@
 package kubedb
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	jsonTypes "github.com/appscode/go/encoding/json/types"
-	"github.com/appscode/go/types"
 	"github.com/golang/glog"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
-	ofst "kmodules.xyz/offshoot-api/api/v1"
 )
 
 type MemcachedProvider struct {
 	extClient cs.KubedbV1alpha1Interface
+	informer  *instanceInformer
 }
 
 func NewMemcachedProvider(config *rest.Config) Provider {
+	extClient := cs.NewForConfigOrDie(config)
 	return &MemcachedProvider{
-		extClient: cs.NewForConfigOrDie(config),
-	}
-}
-
-func demoMemcachedSpec() api.MemcachedSpec {
-	return api.MemcachedSpec{
-		Version:  jsonTypes.StrYo(demoMemcachedVersion),
-		Replicas: types.Int32P(3),
-		PodTemplate: ofst.PodTemplateSpec{
-			Spec: ofst.PodSpec{
-				Resources: corev1.ResourceRequirements{
-					Limits: corev1.ResourceList{
-						corev1.ResourceCPU:    resource.MustParse("500m"),
-						corev1.ResourceMemory: resource.MustParse("128Mi"),
-					},
-					Requests: corev1.ResourceList{
-						corev1.ResourceCPU:    resource.MustParse("250m"),
-						corev1.ResourceMemory: resource.MustParse("64Mi"),
-					},
-				},
+		extClient: extClient,
+		informer: newInstanceInformer(&api.Memcached{},
+			func(options metav1.ListOptions) (runtime.Object, error) {
+				return extClient.Memcacheds(corev1.NamespaceAll).List(options)
 			},
-		},
-		TerminationPolicy: api.TerminationPolicyWipeOut,
+			func(options metav1.ListOptions) (watch.Interface, error) {
+				return extClient.Memcacheds(corev1.NamespaceAll).Watch(options)
+			}),
 	}
 }
 
@@ -55,52 +41,91 @@ func (p MemcachedProvider) Metadata() (string, string) {
 	return "kubedb", "memcached"
 }
 
-func (p MemcachedProvider) Create(provisionInfo ProvisionInfo) error {
+func (p MemcachedProvider) Resource() schema.GroupResource {
+	return api.Resource(api.ResourcePluralMemcached)
+}
+
+func (p MemcachedProvider) Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
 	var mc api.Memcached
 
 	// set metadata from provision info
 	if err := provisionInfo.applyToMetadata(&mc.ObjectMeta); err != nil {
-		return err
+		return nil, err
 	}
 
-	// set postgres spec
-	switch provisionInfo.PlanID {
-	case PlanMemcachedDemo:
-		mc.Spec = demoMemcachedSpec()
-	case PlanMemcached:
-		if err := provisionInfo.applyToSpec(&mc.Spec); err != nil {
-			return err
-		}
+	// set spec from the plan and the params
+	if err := provisionInfo.applyToSpec(&mc.Spec); err != nil {
+		return nil, err
+	}
+	provisionInfo.applyTerminationPolicy(&mc.Spec.TerminationPolicy)
+	if origin := provisionInfo.origin; origin != nil && origin.Spec.Memcached != nil {
+		// KubeDB resumes the DormantDatabase with the spec of its origin
+		mc.Spec = *origin.Spec.Memcached
+		provisionInfo.resumeTerminationPolicy(&mc.Spec.TerminationPolicy)
 	}
 
 	glog.Infof("Creating memcached obj %q in namespace %q...", mc.Name, mc.Namespace)
-	_, err := p.extClient.Memcacheds(mc.Namespace).Create(&mc)
-
-	return err
+	out, err := p.extClient.Memcacheds(mc.Namespace).Create(&mc)
+	if err != nil {
+		return nil, err
+	}
+	return &out.ObjectMeta, nil
 }
 
-func (p MemcachedProvider) Delete(name, namespace string) error {
-	glog.Infof("Deleting memcached obj %q from namespace %q...", name, namespace)
+func (p MemcachedProvider) Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
+	mc, err := p.extClient.Memcacheds(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	mc, err := p.extClient.Memcacheds(namespace).Get(name, metav1.GetOptions{})
+	var spec api.MemcachedSpec
+	if err := provisionInfo.mergeSpecUpdate(mc.Spec, &spec); err != nil {
+		return nil, err
+	}
+
+	meta := mc.ObjectMeta.DeepCopy()
+	if err := provisionInfo.applyToMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Updating memcached obj %q in namespace %q...", mc.Name, mc.Namespace)
+	err = patchMemcached(p.extClient, mc, func(in *api.Memcached) *api.Memcached {
+		in.Labels = meta.Labels
+		in.Annotations = meta.Annotations
+		in.Spec = spec
+		return in
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &mc.ObjectMeta, nil
+}
+
+func (p MemcachedProvider) Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
+	mc, err := p.extClient.Memcacheds(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	if mc.Spec.TerminationPolicy != api.TerminationPolicyWipeOut {
-		if err := patchMemcached(p.extClient, mc, func(in *api.Memcached) *api.Memcached {
-			in.Spec.TerminationPolicy = api.TerminationPolicyWipeOut
-			return in
-		}); err != nil {
-			return err
-		}
+	deletion, err := deletionPolicy(mc.ObjectMeta, mc.Spec.TerminationPolicy, policy)
+	if err != nil {
+		return "", err
+	}
+	if err := patchMemcached(p.extClient, mc, func(in *api.Memcached) *api.Memcached {
+		prepareDeletion(&in.ObjectMeta, &in.Spec.TerminationPolicy, deletion)
+		return in
+	}); err != nil {
+		return "", err
 	}
 
-	if err := p.extClient.Memcacheds(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-		return err
+	glog.Infof("Deleting memcached obj %q from namespace %q with termination policy %s...", name, namespace, deletion)
+	if err := p.extClient.Memcacheds(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return "", err
 	}
 
-	return nil
+	return deletion, nil
 }
 
 func (p MemcachedProvider) Bind(
@@ -123,6 +148,12 @@ func (p MemcachedProvider) Bind(
 		return nil, errors.Wrapf(err, `failed to retrieve "uri" from secret for %s %s/%s`, app.Spec.Type, app.Namespace, app.Name)
 	}
 
+	// memcached has no users, so the roles of the bindings can not be enforced
+	if _, found := params[bindingRoleParam]; found {
+		return nil, badRequest("parameter %s is not supported by %s bindings, memcached has no users",
+			bindingRoleParam, app.Spec.Type)
+	}
+
 	return &Credentials{
 		Protocol: app.Spec.ClientConfig.Service.Scheme,
 		Host:     host,
@@ -132,23 +163,21 @@ func (p MemcachedProvider) Bind(
 }
 
 func (p MemcachedProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
-	memcacheds, err := p.extClient.Memcacheds(corev1.NamespaceAll).List(metav1.ListOptions{
-		LabelSelector: labels.Set{
-			InstanceKey: instanceID,
-		}.String(),
-	})
-	if err != nil || len(memcacheds.Items) == 0 {
-		return nil, err
-	}
+	return p.informer.provisionInfo(instanceID, "Memcacheds")
+}
 
-	if len(memcacheds.Items) > 1 {
-		var instances []string
-		for _, memcached := range memcacheds.Items {
-			instances = append(instances, fmt.Sprintf("%s/%s", memcached.Namespace, memcached.Name))
-		}
+func (p MemcachedProvider) Informer() cache.SharedIndexInformer {
+	return p.informer
+}
 
-		return nil, errors.Errorf("%d Memcacheds with instance id %s found: %s",
-			len(memcacheds.Items), instanceID, strings.Join(instances, ", "))
+func (p MemcachedProvider) GetStatus(name, namespace string) (api.DatabasePhase, string, error) {
+	mc, err := p.extClient.Memcacheds(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
 	}
-	return provisionInfoFromObjectMeta(memcacheds.Items[0].ObjectMeta)
+	return observedPhase(mc.ObjectMeta, mc.Status.Phase, mc.Status.ObservedGeneration), mc.Status.Reason, nil
+}
+
+func (p MemcachedProvider) WaitForReady(name, namespace string, timeout time.Duration) error {
+	return waitForMemcachedBeReady(p.extClient, name, namespace, timeout)
 }
@@ -1,102 +1,131 @@
 package kubedb
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	jsonTypes "github.com/appscode/go/encoding/json/types"
-	"github.com/appscode/go/types"
 	"github.com/golang/glog"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 )
 
 type MongoDbProvider struct {
 	extClient cs.KubedbV1alpha1Interface
+	informer  *instanceInformer
 }
 
 func NewMongoDbProvider(config *rest.Config) Provider {
+	extClient := cs.NewForConfigOrDie(config)
 	return &MongoDbProvider{
-		extClient: cs.NewForConfigOrDie(config),
+		extClient: extClient,
+		informer: newInstanceInformer(&api.MongoDB{},
+			func(options metav1.ListOptions) (runtime.Object, error) {
+				return extClient.MongoDBs(corev1.NamespaceAll).List(options)
+			},
+			func(options metav1.ListOptions) (watch.Interface, error) {
+				return extClient.MongoDBs(corev1.NamespaceAll).Watch(options)
+			}),
 	}
 }
 
-func demoMongoDBSpec() api.MongoDBSpec {
-	return api.MongoDBSpec{
-		Version:           jsonTypes.StrYo(demoMongoDBVersion),
-		StorageType:       api.StorageTypeEphemeral,
-		TerminationPolicy: api.TerminationPolicyWipeOut,
-	}
-}
-
-func demoMongoDBClusterSpec() api.MongoDBSpec {
-	mgSpec := demoMongoDBSpec()
-	mgSpec.Replicas = types.Int32P(3)
-	mgSpec.ReplicaSet = &api.MongoDBReplicaSet{
-		Name: "rs0",
-	}
-
-	return mgSpec
-}
-
 func (p MongoDbProvider) Metadata() (string, string) {
 	return "kubedb", "mongodb"
 }
 
-func (p MongoDbProvider) Create(provisionInfo ProvisionInfo) error {
+func (p MongoDbProvider) Resource() schema.GroupResource {
+	return api.Resource(api.ResourcePluralMongoDB)
+}
+
+func (p MongoDbProvider) Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
 	var mg api.MongoDB
 
 	// set metadata from provision info
 	if err := provisionInfo.applyToMetadata(&mg.ObjectMeta); err != nil {
-		return err
+		return nil, err
 	}
 
-	// set postgres spec
-	switch provisionInfo.PlanID {
-	case PlanMongoDBDemo:
-		mg.Spec = demoMongoDBSpec()
-	case PlanMongoDBClusterDemo:
-		mg.Spec = demoMongoDBClusterSpec()
-	case PlanMongoDB:
-		if err := provisionInfo.applyToSpec(&mg.Spec); err != nil {
-			return err
-		}
+	// set spec from the plan and the params
+	if err := provisionInfo.applyToSpec(&mg.Spec); err != nil {
+		return nil, err
+	}
+	provisionInfo.applyTerminationPolicy(&mg.Spec.TerminationPolicy)
+	if origin := provisionInfo.origin; origin != nil && origin.Spec.MongoDB != nil {
+		// KubeDB resumes the DormantDatabase with the spec of its origin
+		mg.Spec = *origin.Spec.MongoDB
+		provisionInfo.resumeTerminationPolicy(&mg.Spec.TerminationPolicy)
 	}
 
 	glog.Infof("Creating mongodb obj %q in namespace %q...", mg.Name, mg.Namespace)
-	_, err := p.extClient.MongoDBs(mg.Namespace).Create(&mg)
-
-	return err
+	out, err := p.extClient.MongoDBs(mg.Namespace).Create(&mg)
+	if err != nil {
+		return nil, err
+	}
+	return &out.ObjectMeta, nil
 }
 
-func (p MongoDbProvider) Delete(name, namespace string) error {
-	glog.Infof("Deleting mongodb obj %q from namespace %q...", name, namespace)
+func (p MongoDbProvider) Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
+	mg, err := p.extClient.MongoDBs(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var spec api.MongoDBSpec
+	if err := provisionInfo.mergeSpecUpdate(mg.Spec, &spec); err != nil {
+		return nil, err
+	}
 
-	mg, err := p.extClient.MongoDBs(namespace).Get(name, metav1.GetOptions{})
+	meta := mg.ObjectMeta.DeepCopy()
+	if err := provisionInfo.applyToMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Updating mongodb obj %q in namespace %q...", mg.Name, mg.Namespace)
+	err = patchMongoDb(p.extClient, mg, func(in *api.MongoDB) *api.MongoDB {
+		in.Labels = meta.Labels
+		in.Annotations = meta.Annotations
+		in.Spec = spec
+		return in
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &mg.ObjectMeta, nil
+}
+
+func (p MongoDbProvider) Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
+	mg, err := p.extClient.MongoDBs(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	if mg.Spec.TerminationPolicy != api.TerminationPolicyWipeOut {
-		if err := patchMongoDb(p.extClient, mg, func(in *api.MongoDB) *api.MongoDB {
-			in.Spec.TerminationPolicy = api.TerminationPolicyWipeOut
-			return in
-		}); err != nil {
-			return err
-		}
+	deletion, err := deletionPolicy(mg.ObjectMeta, mg.Spec.TerminationPolicy, policy)
+	if err != nil {
+		return "", err
+	}
+	if err := patchMongoDb(p.extClient, mg, func(in *api.MongoDB) *api.MongoDB {
+		prepareDeletion(&in.ObjectMeta, &in.Spec.TerminationPolicy, deletion)
+		return in
+	}); err != nil {
+		return "", err
 	}
 
-	if err := p.extClient.MongoDBs(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-		return err
+	glog.Infof("Deleting mongodb obj %q from namespace %q with termination policy %s...", name, namespace, deletion)
+	if err := p.extClient.MongoDBs(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return "", err
 	}
 
-	return nil
+	return deletion, nil
 }
 
 func (p MongoDbProvider) Bind(
@@ -134,29 +163,55 @@ func (p MongoDbProvider) Bind(
 		Host:     host,
 		Port:     port,
 		URI:      uri,
+		Database: appDatabase,
 		Username: username,
 		Password: password,
 	}, nil
 }
 
-func (p MongoDbProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
-	mongodbs, err := p.extClient.MongoDBs(corev1.NamespaceAll).List(metav1.ListOptions{
-		LabelSelector: labels.Set{
-			InstanceKey: instanceID,
-		}.String(),
-	})
-	if err != nil || len(mongodbs.Items) == 0 {
-		return nil, err
+func (p MongoDbProvider) createUserScript(role BindingRole) string {
+	var roles string
+	switch role {
+	case BindingRoleOwner:
+		roles = "[{role: 'readWrite', db: '" + appDatabase + "'}, {role: 'dbAdmin', db: '" + appDatabase + "'}]"
+	case BindingRoleReadWrite:
+		roles = "[{role: 'readWrite', db: '" + appDatabase + "'}]"
+	case BindingRoleReadOnly:
+		roles = "[{role: 'read', db: '" + appDatabase + "'}]"
 	}
 
-	if len(mongodbs.Items) > 1 {
-		var instances []string
-		for _, mongodb := range mongodbs.Items {
-			instances = append(instances, fmt.Sprintf("%s/%s", mongodb.Namespace, mongodb.Name))
-		}
+	// the roles are granted on the application database only, the users are kept in the admin database
+	return `mongo --quiet --host "$DB_HOST" --port "$DB_PORT" -u "$ADMIN_USERNAME" -p "$ADMIN_PASSWORD" --authenticationDatabase admin admin --eval "
+if (db.getUser('$USERNAME') == null) {
+  db.createUser({user: '$USERNAME', pwd: '$PASSWORD', roles: ` + roles + `});
+}"
+`
+}
+
+func (p MongoDbProvider) dropUserScript() string {
+	return `mongo --quiet --host "$DB_HOST" --port "$DB_PORT" -u "$ADMIN_USERNAME" -p "$ADMIN_PASSWORD" --authenticationDatabase admin admin --eval "
+if (db.getUser('$USERNAME') != null) {
+  db.dropUser('$USERNAME');
+}"
+`
+}
+
+func (p MongoDbProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
+	return p.informer.provisionInfo(instanceID, "MongoDBs")
+}
 
-		return nil, errors.Errorf("%d MongoDBs with instance id %s found: %s",
-			len(mongodbs.Items), instanceID, strings.Join(instances, ", "))
+func (p MongoDbProvider) Informer() cache.SharedIndexInformer {
+	return p.informer
+}
+
+func (p MongoDbProvider) GetStatus(name, namespace string) (api.DatabasePhase, string, error) {
+	mg, err := p.extClient.MongoDBs(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
 	}
-	return provisionInfoFromObjectMeta(mongodbs.Items[0].ObjectMeta)
+	return observedPhase(mg.ObjectMeta, mg.Status.Phase, mg.Status.ObservedGeneration), mg.Status.Reason, nil
+}
+
+func (p MongoDbProvider) WaitForReady(name, namespace string, timeout time.Duration) error {
+	return waitForMongoDbBeReady(p.extClient, name, namespace, timeout)
 }
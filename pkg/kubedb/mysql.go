@@ -1,36 +1,39 @@
 package kubedb
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	jsonTypes "github.com/appscode/go/encoding/json/types"
 	"github.com/golang/glog"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 )
 
 type MySQLProvider struct {
 	extClient cs.KubedbV1alpha1Interface
+	informer  *instanceInformer
 }
 
 func NewMySQLProvider(config *rest.Config) Provider {
+	extClient := cs.NewForConfigOrDie(config)
 	return &MySQLProvider{
-		extClient: cs.NewForConfigOrDie(config),
-	}
-}
-
-func demoMySQLSpec() api.MySQLSpec {
-	return api.MySQLSpec{
-		Version:           jsonTypes.StrYo(demoMySQLVersion),
-		StorageType:       api.StorageTypeEphemeral,
-		TerminationPolicy: api.TerminationPolicyWipeOut,
+		extClient: extClient,
+		informer: newInstanceInformer(&api.MySQL{},
+			func(options metav1.ListOptions) (runtime.Object, error) {
+				return extClient.MySQLs(corev1.NamespaceAll).List(options)
+			},
+			func(options metav1.ListOptions) (watch.Interface, error) {
+				return extClient.MySQLs(corev1.NamespaceAll).Watch(options)
+			}),
 	}
 }
 
@@ -38,52 +41,91 @@ func (p MySQLProvider) Metadata() (string, string) {
 	return "kubedb", "mysql"
 }
 
-func (p MySQLProvider) Create(provisionInfo ProvisionInfo) error {
+func (p MySQLProvider) Resource() schema.GroupResource {
+	return api.Resource(api.ResourcePluralMySQL)
+}
+
+func (p MySQLProvider) Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
 	var my api.MySQL
 
 	// set metadata from provision info
 	if err := provisionInfo.applyToMetadata(&my.ObjectMeta); err != nil {
-		return err
+		return nil, err
 	}
 
-	// set postgres spec
-	switch provisionInfo.PlanID {
-	case PlanMySQLDemo:
-		my.Spec = demoMySQLSpec()
-	case PlanMySQL:
-		if err := provisionInfo.applyToSpec(&my.Spec); err != nil {
-			return err
-		}
+	// set spec from the plan and the params
+	if err := provisionInfo.applyToSpec(&my.Spec); err != nil {
+		return nil, err
+	}
+	provisionInfo.applyTerminationPolicy(&my.Spec.TerminationPolicy)
+	if origin := provisionInfo.origin; origin != nil && origin.Spec.MySQL != nil {
+		// KubeDB resumes the DormantDatabase with the spec of its origin
+		my.Spec = *origin.Spec.MySQL
+		provisionInfo.resumeTerminationPolicy(&my.Spec.TerminationPolicy)
 	}
 
 	glog.Infof("Creating mysql obj %q in namespace %q...", my.Name, my.Namespace)
-	_, err := p.extClient.MySQLs(my.Namespace).Create(&my)
-
-	return err
+	out, err := p.extClient.MySQLs(my.Namespace).Create(&my)
+	if err != nil {
+		return nil, err
+	}
+	return &out.ObjectMeta, nil
 }
 
-func (p MySQLProvider) Delete(name, namespace string) error {
-	glog.Infof("Deleting mysql obj %q from namespace %q...", name, namespace)
+func (p MySQLProvider) Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
+	my, err := p.extClient.MySQLs(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	my, err := p.extClient.MySQLs(namespace).Get(name, metav1.GetOptions{})
+	var spec api.MySQLSpec
+	if err := provisionInfo.mergeSpecUpdate(my.Spec, &spec); err != nil {
+		return nil, err
+	}
+
+	meta := my.ObjectMeta.DeepCopy()
+	if err := provisionInfo.applyToMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Updating mysql obj %q in namespace %q...", my.Name, my.Namespace)
+	err = patchMySQL(p.extClient, my, func(in *api.MySQL) *api.MySQL {
+		in.Labels = meta.Labels
+		in.Annotations = meta.Annotations
+		in.Spec = spec
+		return in
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &my.ObjectMeta, nil
+}
 
-	if my.Spec.TerminationPolicy != api.TerminationPolicyWipeOut {
-		if err := patchMySQL(p.extClient, my, func(in *api.MySQL) *api.MySQL {
-			in.Spec.TerminationPolicy = api.TerminationPolicyWipeOut
-			return in
-		}); err != nil {
-			return err
-		}
+func (p MySQLProvider) Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
+	my, err := p.extClient.MySQLs(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	if err := p.extClient.MySQLs(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-		return err
+	deletion, err := deletionPolicy(my.ObjectMeta, my.Spec.TerminationPolicy, policy)
+	if err != nil {
+		return "", err
+	}
+	if err := patchMySQL(p.extClient, my, func(in *api.MySQL) *api.MySQL {
+		prepareDeletion(&in.ObjectMeta, &in.Spec.TerminationPolicy, deletion)
+		return in
+	}); err != nil {
+		return "", err
 	}
 
-	return nil
+	glog.Infof("Deleting mysql obj %q from namespace %q with termination policy %s...", name, namespace, deletion)
+	if err := p.extClient.MySQLs(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return "", err
+	}
+
+	return deletion, nil
 }
 
 func (p MySQLProvider) Bind(
@@ -121,29 +163,55 @@ func (p MySQLProvider) Bind(
 		Host:     host,
 		Port:     port,
 		URI:      uri,
+		Database: appDatabase,
 		Username: username,
 		Password: password,
 	}, nil
 }
 
-func (p MySQLProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
-	mysqls, err := p.extClient.MySQLs(corev1.NamespaceAll).List(metav1.ListOptions{
-		LabelSelector: labels.Set{
-			InstanceKey: instanceID,
-		}.String(),
-	})
-	if err != nil || len(mysqls.Items) == 0 {
-		return nil, err
+func (p MySQLProvider) createUserScript(role BindingRole) string {
+	var privileges string
+	switch role {
+	case BindingRoleOwner:
+		privileges = "ALL PRIVILEGES"
+	case BindingRoleReadWrite:
+		privileges = "SELECT, INSERT, UPDATE, DELETE, EXECUTE"
+	case BindingRoleReadOnly:
+		privileges = "SELECT"
 	}
 
-	if len(mysqls.Items) > 1 {
-		var instances []string
-		for _, mysql := range mysqls.Items {
-			instances = append(instances, fmt.Sprintf("%s/%s", mysql.Namespace, mysql.Name))
-		}
+	// the privileges are granted on the application database only, the system schemas are left to the admin user
+	return `mysql -h "$DB_HOST" -P "$DB_PORT" -u "$ADMIN_USERNAME" --password="$ADMIN_PASSWORD" <<EOF
+CREATE DATABASE IF NOT EXISTS ` + appDatabase + `;
+CREATE USER IF NOT EXISTS '$USERNAME'@'%' IDENTIFIED BY '$PASSWORD';
+GRANT ` + privileges + ` ON ` + appDatabase + `.* TO '$USERNAME'@'%';
+EOF
+`
+}
+
+func (p MySQLProvider) dropUserScript() string {
+	return `mysql -h "$DB_HOST" -P "$DB_PORT" -u "$ADMIN_USERNAME" --password="$ADMIN_PASSWORD" <<EOF
+DROP USER IF EXISTS '$USERNAME'@'%';
+EOF
+`
+}
+
+func (p MySQLProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
+	return p.informer.provisionInfo(instanceID, "MySQLs")
+}
 
-		return nil, errors.Errorf("%d MySQLs with instance id %s found: %s",
-			len(mysqls.Items), instanceID, strings.Join(instances, ", "))
+func (p MySQLProvider) Informer() cache.SharedIndexInformer {
+	return p.informer
+}
+
+func (p MySQLProvider) GetStatus(name, namespace string) (api.DatabasePhase, string, error) {
+	my, err := p.extClient.MySQLs(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
 	}
-	return provisionInfoFromObjectMeta(mysqls.Items[0].ObjectMeta)
+	return observedPhase(my.ObjectMeta, my.Status.Phase, my.Status.ObservedGeneration), my.Status.Reason, nil
+}
+
+func (p MySQLProvider) WaitForReady(name, namespace string, timeout time.Duration) error {
+	return waitForMySQLBeReady(p.extClient, name, namespace, timeout)
 }
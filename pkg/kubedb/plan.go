@@ -0,0 +1,101 @@
+package kubedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Plan is a plan of a service in the catalog. The databases of a plan are created with the spec template
+// of the plan merged with the spec param. The spec param is required for the plans without a spec template.
+//
+// The spec template is a spec of the KubeDB object of the service, e.g. the version, replicas, storage,
+// resources and topology of the databases:
+//
+//	plans:
+//	- id: 2f2c7f4d-3b5e-4a8e-9d55-7a3b6f0c1e21
+//	  name: small-postgresql
+//	  description: Standalone PostgreSQL database with 1Gi storage
+//	  spec:
+//	    version: "11.1-v1"
+//	    storageType: Durable
+//	    storage:
+//	      resources:
+//	        requests:
+//	          storage: 1Gi
+//
+// The spec templates are not served in the catalog.
+type Plan struct {
+	ID   string                 `json:"id"`
+	Name string                 `json:"name"`
+	Spec map[string]interface{} `json:"spec,omitempty"`
+}
+
+// SetCatalog sets the path and the names of the catalogs, those the plans of the services are read from.
+// The catalogs are read on every request, so that the plans can be changed without a restart, e.g. by
+// editing the ConfigMaps the catalogs are mounted from.
+func (c *Client) SetCatalog(catalogPath string, catalogNames []string) {
+	c.catalogPath = catalogPath
+	c.catalogNames = catalogNames
+}
+
+// readCatalog reads the catalog file of a service.
+func readCatalog(catalogPath, catalog, serviceName string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(catalogPath, catalog, fmt.Sprintf("%s.yaml", serviceName)))
+}
+
+// plan returns the plan of a service from its catalog. It returns 400 Bad Request, if the plan is not offered.
+func (c *Client) plan(serviceID, planID string) (*Plan, error) {
+	provider, exists := c.serviceProviders[serviceID]
+	if !exists {
+		return nil, unknownService(serviceID)
+	}
+	catalog, serviceName := provider.Metadata()
+	if !sets.NewString(c.catalogNames...).Has(catalog) {
+		return nil, unknownService(serviceID)
+	}
+
+	data, err := readCatalog(c.catalogPath, catalog, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	// the spec templates are decoded from JSON, so that they are merged with the params
+	data, err = yaml.ToJSON(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the plans of service %q", serviceID)
+	}
+	var service struct {
+		Plans []Plan `json:"plans"`
+	}
+	if err := json.Unmarshal(data, &service); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the plans of service %q", serviceID)
+	}
+	for i := range service.Plans {
+		if service.Plans[i].ID == planID {
+			return &service.Plans[i], nil
+		}
+	}
+	return nil, unknownPlan(serviceID, planID)
+}
+
+// specTemplate returns a copy of the spec template of a plan, so that merging the params leaves the plan untouched.
+// It returns nil for a plan without a spec template.
+func (p *Plan) specTemplate() (map[string]interface{}, error) {
+	if p == nil || p.Spec == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(p.Spec)
+	if err != nil {
+		return nil, err
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
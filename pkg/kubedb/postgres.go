@@ -1,101 +1,132 @@
 package kubedb
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	jsonTypes "github.com/appscode/go/encoding/json/types"
-	"github.com/appscode/go/types"
 	"github.com/golang/glog"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	k8sLabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 )
 
 type PostgreSQLProvider struct {
 	extClient        cs.KubedbV1alpha1Interface
 	storageClassName string
+	informer         *instanceInformer
 }
 
 func NewPostgreSQLProvider(config *rest.Config) Provider {
+	extClient := cs.NewForConfigOrDie(config)
 	return &PostgreSQLProvider{
-		extClient: cs.NewForConfigOrDie(config),
+		extClient: extClient,
+		informer: newInstanceInformer(&api.Postgres{},
+			func(options metav1.ListOptions) (runtime.Object, error) {
+				return extClient.Postgreses(corev1.NamespaceAll).List(options)
+			},
+			func(options metav1.ListOptions) (watch.Interface, error) {
+				return extClient.Postgreses(corev1.NamespaceAll).Watch(options)
+			}),
 	}
 }
 
-func demoPostgresSpec() api.PostgresSpec {
-	return api.PostgresSpec{
-		Version:           jsonTypes.StrYo(demoPostgresVersion),
-		Replicas:          types.Int32P(1),
-		StorageType:       api.StorageTypeEphemeral,
-		TerminationPolicy: api.TerminationPolicyWipeOut,
-	}
-}
-
-func demoHAPostgresSpec() api.PostgresSpec {
-	pgSpec := demoPostgresSpec()
-	pgSpec.Replicas = types.Int32P(3)
-
-	return pgSpec
-}
-
 func (p PostgreSQLProvider) Metadata() (string, string) {
 	return "kubedb", "postgresql"
 }
 
-func (p PostgreSQLProvider) Create(provisionInfo ProvisionInfo) error {
+func (p PostgreSQLProvider) Resource() schema.GroupResource {
+	return api.Resource(api.ResourcePluralPostgres)
+}
+
+func (p PostgreSQLProvider) Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
 	var pg api.Postgres
 
 	// set metadata from provision info
 	if err := provisionInfo.applyToMetadata(&pg.ObjectMeta); err != nil {
-		return err
+		return nil, err
 	}
 
-	// set postgres spec
-	switch provisionInfo.PlanID {
-	case PlanPostgresDemo:
-		pg.Spec = demoPostgresSpec()
-	case PlanPostgresHADemo:
-		pg.Spec = demoHAPostgresSpec()
-	case PlanPostgres:
-		if err := provisionInfo.applyToSpec(&pg.Spec); err != nil {
-			return err
-		}
+	// set spec from the plan and the params
+	if err := provisionInfo.applyToSpec(&pg.Spec); err != nil {
+		return nil, err
+	}
+	provisionInfo.applyTerminationPolicy(&pg.Spec.TerminationPolicy)
+	if origin := provisionInfo.origin; origin != nil && origin.Spec.Postgres != nil {
+		// KubeDB resumes the DormantDatabase with the spec of its origin
+		pg.Spec = *origin.Spec.Postgres
+		provisionInfo.resumeTerminationPolicy(&pg.Spec.TerminationPolicy)
 	}
 
 	glog.Infof("Creating postgres obj %q in namespace %q...", pg.Name, pg.Namespace)
-	_, err := p.extClient.Postgreses(pg.Namespace).Create(&pg)
-
-	return err
+	out, err := p.extClient.Postgreses(pg.Namespace).Create(&pg)
+	if err != nil {
+		return nil, err
+	}
+	return &out.ObjectMeta, nil
 }
 
-func (p PostgreSQLProvider) Delete(name, namespace string) error {
-	glog.Infof("Deleting postgres obj %q from namespace %q...", name, namespace)
+func (p PostgreSQLProvider) Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
+	pg, err := p.extClient.Postgreses(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var spec api.PostgresSpec
+	if err := provisionInfo.mergeSpecUpdate(pg.Spec, &spec); err != nil {
+		return nil, err
+	}
 
-	pgsql, err := p.extClient.Postgreses(namespace).Get(name, metav1.GetOptions{})
+	meta := pg.ObjectMeta.DeepCopy()
+	if err := provisionInfo.applyToMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Updating postgres obj %q in namespace %q...", pg.Name, pg.Namespace)
+	err = patchPostgreSQL(p.extClient, pg, func(in *api.Postgres) *api.Postgres {
+		in.Labels = meta.Labels
+		in.Annotations = meta.Annotations
+		in.Spec = spec
+		return in
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &pg.ObjectMeta, nil
+}
+
+func (p PostgreSQLProvider) Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
+	pgsql, err := p.extClient.Postgreses(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	if pgsql.Spec.TerminationPolicy != api.TerminationPolicyWipeOut {
-		if err := patchPostgreSQL(p.extClient, pgsql, func(in *api.Postgres) *api.Postgres {
-			in.Spec.TerminationPolicy = api.TerminationPolicyWipeOut
-			return in
-		}); err != nil {
-			return err
-		}
+	deletion, err := deletionPolicy(pgsql.ObjectMeta, pgsql.Spec.TerminationPolicy, policy)
+	if err != nil {
+		return "", err
+	}
+	if err := patchPostgreSQL(p.extClient, pgsql, func(in *api.Postgres) *api.Postgres {
+		prepareDeletion(&in.ObjectMeta, &in.Spec.TerminationPolicy, deletion)
+		return in
+	}); err != nil {
+		return "", err
 	}
 
-	if err := p.extClient.Postgreses(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-		return err
+	glog.Infof("Deleting postgres obj %q from namespace %q with termination policy %s...", name, namespace, deletion)
+	if err := p.extClient.Postgreses(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return "", err
 	}
 
-	return nil
+	return deletion, nil
 }
 
 func (p PostgreSQLProvider) Bind(
@@ -138,24 +169,80 @@ func (p PostgreSQLProvider) Bind(
 	}, nil
 }
 
-func (p PostgreSQLProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
-	postgreses, err := p.extClient.Postgreses(corev1.NamespaceAll).List(metav1.ListOptions{
-		LabelSelector: k8sLabels.Set{
-			InstanceKey: instanceID,
-		}.String(),
-	})
-	if err != nil || len(postgreses.Items) == 0 {
-		return nil, err
+func (p PostgreSQLProvider) createUserScript(role BindingRole) string {
+	var grants string
+	switch role {
+	case BindingRoleOwner:
+		grants = `
+ALTER ROLE "$USERNAME" CREATEDB;
+GRANT ALL PRIVILEGES ON DATABASE postgres TO "$USERNAME";
+GRANT ALL ON SCHEMA public TO "$USERNAME";
+GRANT ALL ON ALL TABLES IN SCHEMA public TO "$USERNAME";
+GRANT ALL ON ALL SEQUENCES IN SCHEMA public TO "$USERNAME";
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL ON TABLES TO "$USERNAME";
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT ALL ON SEQUENCES TO "$USERNAME";`
+	case BindingRoleReadWrite:
+		grants = `
+GRANT CONNECT ON DATABASE postgres TO "$USERNAME";
+GRANT USAGE ON SCHEMA public TO "$USERNAME";
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO "$USERNAME";
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO "$USERNAME";
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO "$USERNAME";
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO "$USERNAME";`
+	case BindingRoleReadOnly:
+		grants = `
+GRANT CONNECT ON DATABASE postgres TO "$USERNAME";
+GRANT USAGE ON SCHEMA public TO "$USERNAME";
+GRANT SELECT ON ALL TABLES IN SCHEMA public TO "$USERNAME";
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT ON TABLES TO "$USERNAME";`
 	}
 
-	if len(postgreses.Items) > 1 {
-		var instances []string
-		for _, postgres := range postgreses.Items {
-			instances = append(instances, fmt.Sprintf("%s/%s", postgres.Namespace, postgres.Name))
-		}
+	return `export PGPASSWORD="$ADMIN_PASSWORD"
+psql -v ON_ERROR_STOP=1 -h "$DB_HOST" -p "$DB_PORT" -U "$ADMIN_USERNAME" -d postgres <<EOF
+DO \$\$
+BEGIN
+  IF NOT EXISTS (SELECT FROM pg_roles WHERE rolname = '$USERNAME') THEN
+    CREATE ROLE "$USERNAME" LOGIN PASSWORD '$PASSWORD';
+  END IF;
+END
+\$\$;` + grants + `
+EOF
+`
+}
+
+func (p PostgreSQLProvider) dropUserScript() string {
+	return `export PGPASSWORD="$ADMIN_PASSWORD"
+psql -v ON_ERROR_STOP=1 -h "$DB_HOST" -p "$DB_PORT" -U "$ADMIN_USERNAME" -d postgres <<EOF
+DO \$\$
+BEGIN
+  IF EXISTS (SELECT FROM pg_roles WHERE rolname = '$USERNAME') THEN
+    PERFORM pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = '$USERNAME';
+    REASSIGN OWNED BY "$USERNAME" TO "$ADMIN_USERNAME";
+    DROP OWNED BY "$USERNAME";
+    DROP ROLE "$USERNAME";
+  END IF;
+END
+\$\$;
+EOF
+`
+}
+
+func (p PostgreSQLProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
+	return p.informer.provisionInfo(instanceID, "Postgreses")
+}
 
-		return nil, errors.Errorf("%d Postgreses with instance id %s found: %s",
-			len(postgreses.Items), instanceID, strings.Join(instances, ", "))
+func (p PostgreSQLProvider) Informer() cache.SharedIndexInformer {
+	return p.informer
+}
+
+func (p PostgreSQLProvider) GetStatus(name, namespace string) (api.DatabasePhase, string, error) {
+	pg, err := p.extClient.Postgreses(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
 	}
-	return provisionInfoFromObjectMeta(postgreses.Items[0].ObjectMeta)
+	return observedPhase(pg.ObjectMeta, pg.Status.Phase, pg.Status.ObservedGeneration), pg.Status.Reason, nil
+}
+
+func (p PostgreSQLProvider) WaitForReady(name, namespace string, timeout time.Duration) error {
+	return waitForPostgreSQLBeReady(p.extClient, name, namespace, timeout)
 }
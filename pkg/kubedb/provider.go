@@ -2,20 +2,38 @@ package kubedb
 
 import (
 	"encoding/json"
+	"net/http"
 	"reflect"
+	"time"
 
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
 	mu "kmodules.xyz/client-go/meta"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 )
 
 type Provider interface {
 	Metadata() (catalog string, serviceName string)
+	// Resource returns the resource of the KubeDB objects of the provider
+	Resource() schema.GroupResource
 	Bind(app *appcat.AppBinding, params map[string]interface{}, chartSecrets map[string]interface{}) (*Credentials, error)
-	Create(provisionInfo ProvisionInfo) error
-	Delete(name, namespace string) error
+	// Create creates the KubeDB object of an instance and returns the metadata of the created object
+	Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error)
+	// Update updates the KubeDB object of an instance and returns the metadata of the object
+	Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error)
+	// Delete deletes the KubeDB object of an instance with the requested termination policy, or with its own
+	// policy if none is requested, and returns the policy it is deleted with. It is a no-op, if the object is not found.
+	Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error)
 	GetProvisionInfo(instanceID string) (*ProvisionInfo, error)
+	// GetStatus returns the phase of the KubeDB object of an instance along with its reason. The phase is empty,
+	// while the operator has not observed the latest generation of the object.
+	GetStatus(name, namespace string) (api.DatabasePhase, string, error)
+	WaitForReady(name, namespace string, timeout time.Duration) error
+	// Informer returns the informer that caches the objects of the provider
+	Informer() cache.SharedIndexInformer
 }
 
 type ProvisionInfo struct {
@@ -24,16 +42,33 @@ type ProvisionInfo struct {
 	PlanID      string
 	Params      map[string]interface{}
 	ExtraParams map[string]interface{}
+	// Labels those record the origin of the instance
+	Labels map[string]string
+	// Name of the Secret that holds the sensitive params, those are redacted in Params
+	ParamsSecret string `json:",omitempty"`
 
 	InstanceName string
 	Namespace    string
+
+	// Params without the sensitive params, those are recorded in the annotation if redacted is set
+	publicParams map[string]interface{}
+	redacted     bool
+	// Sensitive params those are kept in the Secret
+	sensitiveParams map[string]interface{}
+	// Termination policy of the plan, that is applied unless the params set one
+	terminationPolicy api.TerminationPolicy
+	// Origin of the DormantDatabase the instance resumes, nil if it is not restored
+	origin *api.Origin
+	// Plan of the instance, and the plan it is updated from
+	plan     *Plan
+	prevPlan *Plan
 }
 
-func provisionInfoFromObjectMeta(meta metav1.ObjectMeta) (*ProvisionInfo, error) {
+func provisionInfoFromObjectMeta(meta metav1.Object) (*ProvisionInfo, error) {
 	var provisionInfo ProvisionInfo
-	err := json.Unmarshal([]byte(meta.Annotations[ProvisionInfoKey]), &provisionInfo)
+	err := json.Unmarshal([]byte(meta.GetAnnotations()[ProvisionInfoKey]), &provisionInfo)
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not unmarshal provision info for instance %q", meta.Labels[InstanceKey])
+		return nil, errors.Wrapf(err, "could not unmarshal provision info for instance %q", meta.GetLabels()[InstanceKey])
 	}
 	return &provisionInfo, nil
 }
@@ -48,7 +83,7 @@ func (p *ProvisionInfo) Match(q *ProvisionInfo) bool {
 func (p ProvisionInfo) applyToMetadata(meta *metav1.ObjectMeta) error {
 	if _, found := p.Params["metadata"]; found {
 		if err := mu.Decode(p.Params["metadata"], meta); err != nil {
-			return err
+			return badRequest("invalid metadata: %v", err)
 		}
 	}
 
@@ -59,6 +94,9 @@ func (p ProvisionInfo) applyToMetadata(meta *metav1.ObjectMeta) error {
 	if meta.Labels == nil {
 		meta.Labels = make(map[string]string)
 	}
+	for k, v := range p.Labels {
+		meta.Labels[k] = v
+	}
 	meta.Labels[InstanceKey] = p.InstanceID
 
 	// ref: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/#labels
@@ -71,8 +109,12 @@ func (p ProvisionInfo) applyToMetadata(meta *metav1.ObjectMeta) error {
 	if meta.Annotations == nil {
 		meta.Annotations = make(map[string]string)
 	}
-	if provisionInfoJson, err := json.Marshal(p); err != nil {
-		return errors.Wrapf(err, "could not marshal provisioning info %v", p)
+	recorded := p
+	if p.redacted {
+		recorded.Params = p.publicParams
+	}
+	if provisionInfoJson, err := json.Marshal(recorded); err != nil {
+		return errors.Wrapf(err, "could not marshal provisioning info of instance %q", p.InstanceID)
 	} else {
 		meta.Annotations[ProvisionInfoKey] = string(provisionInfoJson)
 	}
@@ -80,11 +122,33 @@ func (p ProvisionInfo) applyToMetadata(meta *metav1.ObjectMeta) error {
 	return nil
 }
 
+func planUpdateNotSupported(fromPlanID, toPlanID string) error {
+	return statusError(http.StatusUnprocessableEntity, nil, "Updating plan from %q to %q is not supported", fromPlanID, toPlanID)
+}
+
+// applyToSpec sets the spec of a database from the spec template of its plan merged with the spec param.
+// The spec param is required for the plans without a spec template.
 func (p ProvisionInfo) applyToSpec(spec interface{}) error {
-	if _, found := p.Params["spec"]; !found {
-		return errors.New("spec is required for provisioning custom postgres")
+	doc, err := p.plan.specTemplate()
+	if err != nil {
+		return err
 	}
-	return mu.Decode(p.Params["spec"], spec)
+	param, found := p.Params["spec"]
+	if !found && doc == nil {
+		return badRequest("parameter spec is required for plan %q", p.PlanID)
+	}
+	if found {
+		update, ok := param.(map[string]interface{})
+		if !ok {
+			return badRequest("spec must be an object")
+		}
+		doc = mergePatch(doc, update)
+	}
+
+	if err := mu.Decode(doc, spec); err != nil {
+		return badRequest("invalid spec: %v", err)
+	}
+	return nil
 }
 
 // ref: https://github.com/osbkit/minibroker/blob/d212fcb0013fe73eae914543525e36a1b1fc91cd/pkg/minibroker/provider.go#L14:6
@@ -93,6 +157,7 @@ type Credentials struct {
 	Host     string      `json:"host,omitempty"`
 	Port     int32       `json:"port,omitempty"`
 	URI      string      `json:"uri,omitempty"`
+	Database string      `json:"database,omitempty"`
 	Username interface{} `json:"username,omitempty"`
 	Password interface{} `json:"password,omitempty"`
 	RootCert interface{} `json:"rootCert,omitempty"`
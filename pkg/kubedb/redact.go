@@ -0,0 +1,357 @@
+package kubedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	core_util "kmodules.xyz/client-go/core/v1"
+	mu "kmodules.xyz/client-go/meta"
+)
+
+const (
+	// Placeholder of the sensitive params in the provision info of an instance
+	RedactedValue = "<redacted>"
+
+	// Key of the sensitive params in the Secret of an instance
+	keySensitiveParams = "params.json"
+)
+
+// DefaultSensitiveFields are the names of the params those are sensitive, along with everything under them,
+// wherever they are found in the params. The names are matched case-insensitively.
+var DefaultSensitiveFields = []string{
+	"password",
+	"passwd",
+	"token",
+	"credentials",
+	"privateKey",
+	"accessKey",
+	"secretKey",
+	"clientSecret",
+	"databaseSecret",
+}
+
+// ParamRedactor separates the sensitive params of the instances, so that they are not exposed in the
+// provision info annotation of the KubeDB objects nor in the logs.
+type ParamRedactor struct {
+	// lower case names of the sensitive fields
+	fields sets.String
+	// paths of the sensitive params, a path element "*" matches any field or index
+	paths [][]string
+}
+
+// NewParamRedactor returns a redactor of the params with the given names of the fields, and the
+// paths of the params in the dot notation, e.g. spec.init.scriptSource.
+func NewParamRedactor(fields, paths []string) *ParamRedactor {
+	r := &ParamRedactor{fields: sets.NewString()}
+	for _, field := range fields {
+		r.fields.Insert(strings.ToLower(field))
+	}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			r.paths = append(r.paths, strings.Split(path, "."))
+		}
+	}
+	return r
+}
+
+func (r *ParamRedactor) sensitive(path []string) bool {
+	if len(path) > 0 && r.fields.Has(strings.ToLower(path[len(path)-1])) {
+		return true
+	}
+	for _, p := range r.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		matched := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Split returns a copy of the params where the sensitive params are replaced with RedactedValue,
+// along with the sensitive params by their JSON pointers.
+func (r *ParamRedactor) Split(params map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	if params == nil {
+		return nil, nil
+	}
+
+	sensitive := map[string]interface{}{}
+	public := r.split(params, nil, sensitive).(map[string]interface{})
+	if len(sensitive) == 0 {
+		sensitive = nil
+	}
+	return public, sensitive
+}
+
+func (r *ParamRedactor) split(value interface{}, path []string, sensitive map[string]interface{}) interface{} {
+	if len(path) > 0 && r.sensitive(path) {
+		sensitive[jsonPointer(path)] = value
+		return RedactedValue
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			out[key] = r.split(elem, append(path[:len(path):len(path)], key), sensitive)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = r.split(elem, append(path[:len(path):len(path)], strconv.Itoa(i)), sensitive)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Redact returns a copy of the params those can be logged or recorded.
+func (r *ParamRedactor) Redact(params map[string]interface{}) map[string]interface{} {
+	public, _ := r.Split(params)
+	return public
+}
+
+// restoreParams returns a copy of the params where the sensitive params those are still redacted
+// are restored. The sensitive params those are changed or removed in the meantime are left as they are.
+func restoreParams(params, sensitive map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var restored map[string]interface{}
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, err
+	}
+
+	for pointer, value := range sensitive {
+		path := parseJSONPointer(pointer)
+		if len(path) == 0 {
+			continue
+		}
+
+		var parent interface{} = restored
+		for _, elem := range path[:len(path)-1] {
+			parent = child(parent, elem)
+		}
+		key := path[len(path)-1]
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if p[key] == RedactedValue {
+				p[key] = value
+			}
+		case []interface{}:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(p) && p[i] == RedactedValue {
+				p[i] = value
+			}
+		}
+	}
+	return restored, nil
+}
+
+func child(value interface{}, key string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v[key]
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(v) {
+			return v[i]
+		}
+	}
+	return nil
+}
+
+// ref: https://tools.ietf.org/html/rfc6901
+func jsonPointer(path []string) string {
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+	var b strings.Builder
+	for _, elem := range path {
+		b.WriteString("/")
+		b.WriteString(escaper.Replace(elem))
+	}
+	return b.String()
+}
+
+func parseJSONPointer(pointer string) []string {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil
+	}
+	unescaper := strings.NewReplacer("~1", "/", "~0", "~")
+	path := strings.Split(pointer[1:], "/")
+	for i := range path {
+		path[i] = unescaper.Replace(path[i])
+	}
+	return path
+}
+
+// paramsSecretName returns the name of the Secret that holds the sensitive params of an instance.
+func paramsSecretName(instanceName string) string {
+	return fmt.Sprintf("%s-provision-params", instanceName)
+}
+
+// separateParams leaves the params of an instance redacted in the provision info those are recorded in the
+// annotation, and sets the sensitive params aside, those are saved in the Secret of the instance by
+// saveSensitiveParams once its KubeDB object is created or updated. A Secret of another instance is not taken over.
+func (c *Client) separateParams(provisionInfo *ProvisionInfo) error {
+	public, sensitive := c.redactor.Split(provisionInfo.Params)
+	provisionInfo.publicParams = public
+	provisionInfo.sensitiveParams = sensitive
+	provisionInfo.redacted = true
+	if len(sensitive) == 0 {
+		provisionInfo.ParamsSecret = ""
+		return nil
+	}
+
+	name := paramsSecretName(provisionInfo.InstanceName)
+	secret, err := c.kubeClient.CoreV1().Secrets(provisionInfo.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return wrapError(err, "failed to get the sensitive params of instance %q", provisionInfo.InstanceID)
+	}
+	if err == nil && !ownsParamsSecret(secret, provisionInfo) {
+		return conflict("secret %s/%s of the sensitive params belongs to another instance", secret.Namespace, secret.Name)
+	}
+	provisionInfo.ParamsSecret = name
+	return nil
+}
+
+// ownsParamsSecret returns true, if the Secret of the sensitive params is labeled with the id of the instance,
+// or it is left behind by the paused instance that the instance resumes.
+func ownsParamsSecret(secret *core.Secret, provisionInfo *ProvisionInfo) bool {
+	id, found := secret.Labels[InstanceKey]
+	if found && id == provisionInfo.InstanceID {
+		return true
+	}
+	origin := provisionInfo.origin
+	return origin != nil && found && id == origin.Labels[InstanceKey] && len(secret.OwnerReferences) == 0
+}
+
+// saveSensitiveParams keeps the sensitive params of an instance in its Secret, that is owned by the KubeDB
+// object of the instance. The Secret is deleted, if there are no sensitive params.
+func (c *Client) saveSensitiveParams(provisionInfo ProvisionInfo, owner metav1.OwnerReference) error {
+	name := paramsSecretName(provisionInfo.InstanceName)
+	if len(provisionInfo.sensitiveParams) == 0 {
+		secret, err := c.kubeClient.CoreV1().Secrets(provisionInfo.Namespace).Get(name, metav1.GetOptions{})
+		if kerr.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return wrapError(err, "failed to get the sensitive params of instance %q", provisionInfo.InstanceID)
+		}
+		if !ownsParamsSecret(secret, &provisionInfo) {
+			return nil
+		}
+		return c.deleteParamsSecret(provisionInfo.InstanceName, provisionInfo.Namespace)
+	}
+
+	data, err := json.Marshal(provisionInfo.sensitiveParams)
+	if err != nil {
+		return err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: provisionInfo.Namespace,
+		Labels: map[string]string{
+			InstanceKey:          provisionInfo.InstanceID,
+			mu.ManagedByLabelKey: "appscode-service-broker",
+		},
+	}
+	_, _, err = core_util.CreateOrPatchSecret(c.kubeClient, meta, func(in *core.Secret) *core.Secret {
+		in.Labels = meta.Labels
+		in.OwnerReferences = []metav1.OwnerReference{owner}
+		in.Data = map[string][]byte{
+			keySensitiveParams: data,
+		}
+		return in
+	})
+	if err != nil {
+		return wrapError(err, "failed to save the sensitive params of instance %q", provisionInfo.InstanceID)
+	}
+	return nil
+}
+
+// orphanParamsSecret releases the Secret of the sensitive params of an instance from its KubeDB object,
+// so that the Secret is not garbage collected along with the object of a paused instance.
+func (c *Client) orphanParamsSecret(instanceName, namespace string) error {
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(paramsSecretName(instanceName), metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return wrapError(err, "failed to get the sensitive params of %s/%s", namespace, instanceName)
+	}
+	if len(secret.OwnerReferences) == 0 {
+		return nil
+	}
+	_, _, err = core_util.PatchSecret(c.kubeClient, secret, func(in *core.Secret) *core.Secret {
+		in.OwnerReferences = nil
+		return in
+	})
+	return wrapError(err, "failed to release the sensitive params of %s/%s", namespace, instanceName)
+}
+
+// restoreSensitiveParams restores the sensitive params of an instance from its Secret.
+func (c *Client) restoreSensitiveParams(provisionInfo *ProvisionInfo) error {
+	if provisionInfo.ParamsSecret == "" {
+		return nil
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets(provisionInfo.Namespace).Get(provisionInfo.ParamsSecret, metav1.GetOptions{})
+	if err != nil {
+		return wrapError(err, "failed to get the sensitive params of instance %q", provisionInfo.InstanceID)
+	}
+	var sensitive map[string]interface{}
+	if err := json.Unmarshal(secret.Data[keySensitiveParams], &sensitive); err != nil {
+		return errors.Wrapf(err, "invalid sensitive params of instance %q", provisionInfo.InstanceID)
+	}
+
+	provisionInfo.Params, err = restoreParams(provisionInfo.Params, sensitive)
+	return err
+}
+
+func (c *Client) deleteParamsSecret(instanceName, namespace string) error {
+	err := c.kubeClient.CoreV1().Secrets(namespace).Delete(paramsSecretName(instanceName), &metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return wrapError(err, "failed to delete the sensitive params of %s/%s", namespace, instanceName)
+	}
+	if err == nil {
+		glog.Infof("Deleted the sensitive params of %s/%s", namespace, instanceName)
+	}
+	return nil
+}
+
+// RedactParams returns a copy of the params without the sensitive params, those can be logged or recorded.
+func (c *Client) RedactParams(params map[string]interface{}) map[string]interface{} {
+	return c.redactor.Redact(params)
+}
+
+// SetSensitiveParams sets the paths of the params in the dot notation those are sensitive,
+// in addition to the DefaultSensitiveFields.
+func (c *Client) SetSensitiveParams(paths []string) {
+	c.redactor = NewParamRedactor(DefaultSensitiveFields, paths)
+}
+
+// MatchProvisionInfo compares the provision info of an instance with the requested one,
+// without the sensitive params those are not recorded.
+func (c *Client) MatchProvisionInfo(p, q *ProvisionInfo) bool {
+	redacted := func(info *ProvisionInfo) *ProvisionInfo {
+		out := *info
+		out.Params = c.redactor.Redact(info.Params)
+		return &out
+	}
+	return redacted(p).Match(redacted(q))
+}
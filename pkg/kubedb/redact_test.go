@@ -0,0 +1,182 @@
+package kubedb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// params decodes the params from JSON, the way they are decoded from the requests.
+func params(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &out); err != nil {
+		t.Fatalf("invalid params %s: %v", data, err)
+	}
+	return out
+}
+
+func TestParamRedactorSplit(t *testing.T) {
+	cases := []struct {
+		name      string
+		paths     []string
+		params    string
+		public    string
+		sensitive map[string]interface{}
+	}{
+		{
+			name:   "no sensitive params",
+			params: `{"spec":{"version":"10.2-v2","replicas":1}}`,
+			public: `{"spec":{"version":"10.2-v2","replicas":1}}`,
+		},
+		{
+			name:   "top level field",
+			params: `{"password":"secret","name":"db"}`,
+			public: `{"password":"<redacted>","name":"db"}`,
+			sensitive: map[string]interface{}{
+				"/password": "secret",
+			},
+		},
+		{
+			name:   "field matched case-insensitively along with everything under it",
+			params: `{"spec":{"DatabaseSecret":{"secretName":"db-auth"},"version":"10.2-v2"}}`,
+			public: `{"spec":{"DatabaseSecret":"<redacted>","version":"10.2-v2"}}`,
+			sensitive: map[string]interface{}{
+				"/spec/DatabaseSecret": map[string]interface{}{"secretName": "db-auth"},
+			},
+		},
+		{
+			name:   "fields in arrays",
+			params: `{"users":[{"name":"a","password":"x"},{"name":"b","token":"y"}]}`,
+			public: `{"users":[{"name":"a","password":"<redacted>"},{"name":"b","token":"<redacted>"}]}`,
+			sensitive: map[string]interface{}{
+				"/users/0/password": "x",
+				"/users/1/token":    "y",
+			},
+		},
+		{
+			name:   "path",
+			paths:  []string{"spec.init.scriptSource"},
+			params: `{"spec":{"init":{"scriptSource":{"configMap":{"name":"init"}}}}}`,
+			public: `{"spec":{"init":{"scriptSource":"<redacted>"}}}`,
+			sensitive: map[string]interface{}{
+				"/spec/init/scriptSource": map[string]interface{}{"configMap": map[string]interface{}{"name": "init"}},
+			},
+		},
+		{
+			name:   "path with wildcards",
+			paths:  []string{"spec.backends.*.url"},
+			params: `{"spec":{"backends":[{"url":"a"},{"url":"b"}]}}`,
+			public: `{"spec":{"backends":[{"url":"<redacted>"},{"url":"<redacted>"}]}}`,
+			sensitive: map[string]interface{}{
+				"/spec/backends/0/url": "a",
+				"/spec/backends/1/url": "b",
+			},
+		},
+		{
+			name:   "escaped pointers",
+			paths:  []string{"labels.a/b~c"},
+			params: `{"labels":{"a/b~c":"x"}}`,
+			public: `{"labels":{"a/b~c":"<redacted>"}}`,
+			sensitive: map[string]interface{}{
+				"/labels/a~1b~0c": "x",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := NewParamRedactor(DefaultSensitiveFields, c.paths)
+			public, sensitive := r.Split(params(t, c.params))
+			if expected := params(t, c.public); !reflect.DeepEqual(public, expected) {
+				t.Errorf("expected public params %v, found %v", expected, public)
+			}
+			if !reflect.DeepEqual(sensitive, c.sensitive) {
+				t.Errorf("expected sensitive params %v, found %v", c.sensitive, sensitive)
+			}
+		})
+	}
+}
+
+func TestRestoreParams(t *testing.T) {
+	r := NewParamRedactor(DefaultSensitiveFields, []string{"spec.init.scriptSource"})
+	provisioned := `{
+		"password": "secret",
+		"users": [{"name": "a", "password": "x"}],
+		"spec": {"version": "10.2-v2", "init": {"scriptSource": {"configMap": {"name": "init"}}}}
+	}`
+
+	cases := []struct {
+		name     string
+		update   string
+		restored string
+	}{
+		{
+			name:     "round trip",
+			restored: provisioned,
+		},
+		{
+			name:   "merged with an update",
+			update: `{"spec":{"version":"10.6-v1"},"password":"changed"}`,
+			restored: `{
+				"password": "changed",
+				"users": [{"name": "a", "password": "x"}],
+				"spec": {"version": "10.6-v1", "init": {"scriptSource": {"configMap": {"name": "init"}}}}
+			}`,
+		},
+		{
+			name:   "sensitive params removed by an update",
+			update: `{"spec":{"init":null},"users":[{"name":"b"}]}`,
+			restored: `{
+				"password": "secret",
+				"users": [{"name": "b"}],
+				"spec": {"version": "10.2-v2"}
+			}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			public, sensitive := r.Split(params(t, provisioned))
+			if c.update != "" {
+				var err error
+				if public, err = MergeParams(public, params(t, c.update)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			restored, err := restoreParams(public, sensitive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := params(t, c.restored); !reflect.DeepEqual(restored, expected) {
+				t.Errorf("expected params %v, found %v", expected, restored)
+			}
+		})
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	cases := []struct {
+		path    []string
+		pointer string
+	}{
+		{path: []string{"spec", "version"}, pointer: "/spec/version"},
+		{path: []string{"users", "0", "password"}, pointer: "/users/0/password"},
+		{path: []string{"a/b"}, pointer: "/a~1b"},
+		{path: []string{"m~n"}, pointer: "/m~0n"},
+		{path: []string{"~1"}, pointer: "/~01"},
+		{path: []string{""}, pointer: "/"},
+	}
+
+	for _, c := range cases {
+		if pointer := jsonPointer(c.path); pointer != c.pointer {
+			t.Errorf("expected pointer %q of %q, found %q", c.pointer, c.path, pointer)
+		}
+		if path := parseJSONPointer(c.pointer); !reflect.DeepEqual(path, c.path) {
+			t.Errorf("expected path %q of %q, found %q", c.path, c.pointer, path)
+		}
+	}
+	if path := parseJSONPointer("spec"); path != nil {
+		t.Errorf("expected no path of an invalid pointer, found %q", path)
+	}
+}
@@ -1,36 +1,39 @@
 package kubedb
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	jsonTypes "github.com/appscode/go/encoding/json/types"
 	"github.com/golang/glog"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
 )
 
 type RedisProvider struct {
 	extClient cs.KubedbV1alpha1Interface
+	informer  *instanceInformer
 }
 
 func NewRedisProvider(config *rest.Config) Provider {
+	extClient := cs.NewForConfigOrDie(config)
 	return &RedisProvider{
-		extClient: cs.NewForConfigOrDie(config),
-	}
-}
-
-func demoRedisSpec() api.RedisSpec {
-	return api.RedisSpec{
-		Version:           jsonTypes.StrYo(demoRedisVersion),
-		StorageType:       api.StorageTypeEphemeral,
-		TerminationPolicy: api.TerminationPolicyWipeOut,
+		extClient: extClient,
+		informer: newInstanceInformer(&api.Redis{},
+			func(options metav1.ListOptions) (runtime.Object, error) {
+				return extClient.Redises(corev1.NamespaceAll).List(options)
+			},
+			func(options metav1.ListOptions) (watch.Interface, error) {
+				return extClient.Redises(corev1.NamespaceAll).Watch(options)
+			}),
 	}
 }
 
@@ -38,52 +41,91 @@ func (p RedisProvider) Metadata() (string, string) {
 	return "kubedb", "redis"
 }
 
-func (p RedisProvider) Create(provisionInfo ProvisionInfo) error {
+func (p RedisProvider) Resource() schema.GroupResource {
+	return api.Resource(api.ResourcePluralRedis)
+}
+
+func (p RedisProvider) Create(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
 	var rd api.Redis
 
 	// set metadata from provision info
 	if err := provisionInfo.applyToMetadata(&rd.ObjectMeta); err != nil {
-		return err
+		return nil, err
 	}
 
-	// set postgres spec
-	switch provisionInfo.PlanID {
-	case PlanRedisDemo:
-		rd.Spec = demoRedisSpec()
-	case PlanRedis:
-		if err := provisionInfo.applyToSpec(&rd.Spec); err != nil {
-			return err
-		}
+	// set spec from the plan and the params
+	if err := provisionInfo.applyToSpec(&rd.Spec); err != nil {
+		return nil, err
+	}
+	provisionInfo.applyTerminationPolicy(&rd.Spec.TerminationPolicy)
+	if origin := provisionInfo.origin; origin != nil && origin.Spec.Redis != nil {
+		// KubeDB resumes the DormantDatabase with the spec of its origin
+		rd.Spec = *origin.Spec.Redis
+		provisionInfo.resumeTerminationPolicy(&rd.Spec.TerminationPolicy)
 	}
 
 	glog.Infof("Creating redis obj %q in namespace %q...", rd.Name, rd.Namespace)
-	_, err := p.extClient.Redises(rd.Namespace).Create(&rd)
-
-	return err
+	out, err := p.extClient.Redises(rd.Namespace).Create(&rd)
+	if err != nil {
+		return nil, err
+	}
+	return &out.ObjectMeta, nil
 }
 
-func (p RedisProvider) Delete(name, namespace string) error {
-	glog.Infof("Deleting redis obj %q from namespace %q...", name, namespace)
+func (p RedisProvider) Update(provisionInfo ProvisionInfo) (*metav1.ObjectMeta, error) {
+	rd, err := p.extClient.Redises(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	rd, err := p.extClient.Redises(namespace).Get(name, metav1.GetOptions{})
+	var spec api.RedisSpec
+	if err := provisionInfo.mergeSpecUpdate(rd.Spec, &spec); err != nil {
+		return nil, err
+	}
+
+	meta := rd.ObjectMeta.DeepCopy()
+	if err := provisionInfo.applyToMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Updating redis obj %q in namespace %q...", rd.Name, rd.Namespace)
+	err = patchRedis(p.extClient, rd, func(in *api.Redis) *api.Redis {
+		in.Labels = meta.Labels
+		in.Annotations = meta.Annotations
+		in.Spec = spec
+		return in
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &rd.ObjectMeta, nil
+}
+
+func (p RedisProvider) Delete(name, namespace string, policy api.TerminationPolicy) (api.TerminationPolicy, error) {
+	rd, err := p.extClient.Redises(namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
 
-	if rd.Spec.TerminationPolicy != api.TerminationPolicyWipeOut {
-		if err := patchRedis(p.extClient, rd, func(in *api.Redis) *api.Redis {
-			in.Spec.TerminationPolicy = api.TerminationPolicyWipeOut
-			return in
-		}); err != nil {
-			return err
-		}
+	deletion, err := deletionPolicy(rd.ObjectMeta, rd.Spec.TerminationPolicy, policy)
+	if err != nil {
+		return "", err
+	}
+	if err := patchRedis(p.extClient, rd, func(in *api.Redis) *api.Redis {
+		prepareDeletion(&in.ObjectMeta, &in.Spec.TerminationPolicy, deletion)
+		return in
+	}); err != nil {
+		return "", err
 	}
 
-	if err := p.extClient.Redises(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-		return err
+	glog.Infof("Deleting redis obj %q from namespace %q with termination policy %s...", name, namespace, deletion)
+	if err := p.extClient.Redises(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil && !kerr.IsNotFound(err) {
+		return "", err
 	}
 
-	return nil
+	return deletion, nil
 }
 
 func (p RedisProvider) Bind(
@@ -106,6 +148,12 @@ func (p RedisProvider) Bind(
 		return nil, errors.Wrapf(err, `failed to retrieve "uri" from secret for %s %s/%s`, app.Spec.Type, app.Namespace, app.Name)
 	}
 
+	// redis has no users, so the roles of the bindings can not be enforced
+	if _, found := params[bindingRoleParam]; found {
+		return nil, badRequest("parameter %s is not supported by %s bindings, redis has no users",
+			bindingRoleParam, app.Spec.Type)
+	}
+
 	return &Credentials{
 		Protocol: app.Spec.ClientConfig.Service.Scheme,
 		Host:     host,
@@ -115,23 +163,21 @@ func (p RedisProvider) Bind(
 }
 
 func (p RedisProvider) GetProvisionInfo(instanceID string) (*ProvisionInfo, error) {
-	redises, err := p.extClient.Redises(corev1.NamespaceAll).List(metav1.ListOptions{
-		LabelSelector: labels.Set{
-			InstanceKey: instanceID,
-		}.String(),
-	})
-	if err != nil || len(redises.Items) == 0 {
-		return nil, err
-	}
+	return p.informer.provisionInfo(instanceID, "Redises")
+}
 
-	if len(redises.Items) > 1 {
-		var instances []string
-		for _, redis := range redises.Items {
-			instances = append(instances, fmt.Sprintf("%s/%s", redis.Namespace, redis.Name))
-		}
+func (p RedisProvider) Informer() cache.SharedIndexInformer {
+	return p.informer
+}
 
-		return nil, errors.Errorf("%d Redises with instance id %s found: %s",
-			len(redises.Items), instanceID, strings.Join(instances, ", "))
+func (p RedisProvider) GetStatus(name, namespace string) (api.DatabasePhase, string, error) {
+	rd, err := p.extClient.Redises(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
 	}
-	return provisionInfoFromObjectMeta(redises.Items[0].ObjectMeta)
+	return observedPhase(rd.ObjectMeta, rd.Status.Phase, rd.Status.ObservedGeneration), rd.Status.Reason, nil
+}
+
+func (p RedisProvider) WaitForReady(name, namespace string, timeout time.Duration) error {
+	return waitForRedisBeReady(p.extClient, name, namespace, timeout)
 }
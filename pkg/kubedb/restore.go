@@ -0,0 +1,155 @@
+package kubedb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// RestoreFromParam is the provision parameter that names a paused instance to resume, either by
+	// its instance id or by the namespace and name of its DormantDatabase as <namespace>/<name>
+	RestoreFromParam = "restoreFrom"
+
+	// Interval of collecting the DormantDatabases those are retained longer than the retention period
+	dormantGCInterval = 10 * time.Minute
+)
+
+// SetDormantRetention sets the period the paused instances are retained as DormantDatabases for.
+// Zero retains them until they are deleted manually.
+func (c *Client) SetDormantRetention(retention time.Duration) {
+	c.dormantRetention = retention
+}
+
+// ResolveRestore resolves the paused instance that a provision request resumes by the restoreFrom param.
+// The DormantDatabase of the paused instance has to be of the kind of the service and in the namespace of
+// the instance, and the instance is named after it, so that KubeDB resumes the database with its data.
+// An instance that is named after a paused instance, but does not restore it, is rejected as KubeDB
+// does not create a database over a DormantDatabase.
+func (c *Client) ResolveRestore(provisionInfo *ProvisionInfo) error {
+	provider, exists := c.serviceProviders[provisionInfo.ServiceID]
+	if !exists {
+		return unknownService(provisionInfo.ServiceID)
+	}
+
+	restoreFrom, found := provisionInfo.Params[RestoreFromParam]
+	if !found {
+		_, err := c.extClient.DormantDatabases(provisionInfo.Namespace).Get(provisionInfo.InstanceName, metav1.GetOptions{})
+		if err == nil {
+			return badRequest("DormantDatabase %s/%s is retained, set parameter %s to resume it or provision the instance with another name",
+				provisionInfo.Namespace, provisionInfo.InstanceName, RestoreFromParam)
+		} else if !kerr.IsNotFound(err) {
+			return wrapError(err, "failed to look up DormantDatabase %s/%s", provisionInfo.Namespace, provisionInfo.InstanceName)
+		}
+		return nil
+	}
+	ref, ok := restoreFrom.(string)
+	if !ok || ref == "" {
+		return badRequest("parameter %s must be the id of an instance or <namespace>/<name> of a DormantDatabase", RestoreFromParam)
+	}
+
+	var dd *api.DormantDatabase
+	if parts := strings.Split(ref, "/"); len(parts) == 2 {
+		db, err := c.extClient.DormantDatabases(parts[0]).Get(parts[1], metav1.GetOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return wrapError(err, "failed to look up DormantDatabase %s", ref)
+		}
+		if err == nil {
+			dd = db
+		}
+	} else {
+		dbs, err := c.dormantDatabases(ref)
+		if err != nil {
+			return err
+		}
+		if len(dbs) > 1 {
+			return badRequest("%d DormantDatabases of instance %q found, set parameter %s to <namespace>/<name> of one of them",
+				len(dbs), ref, RestoreFromParam)
+		}
+		if len(dbs) == 1 {
+			dd = dbs[0]
+		}
+	}
+
+	if dd == nil {
+		return badRequest("no paused instance %q found to restore from", ref)
+	}
+	if dd.Spec.WipeOut || dd.Status.Phase == api.DormantDatabasePhaseWipingOut || dd.Status.Phase == api.DormantDatabasePhaseWipedOut {
+		return badRequest("the data of paused instance %q is wiped out", ref)
+	}
+	if kind := kinds[provider.Resource().Resource]; dd.Labels[api.LabelDatabaseKind] != kind {
+		return badRequest("paused instance %q is not a %s", ref, kind)
+	}
+	if dd.Namespace != provisionInfo.Namespace {
+		return badRequest("paused instance %q is retained in namespace %s, it can only be restored in the same namespace", ref, dd.Namespace)
+	}
+
+	glog.Infof("Instance %q resumes DormantDatabase %s/%s", provisionInfo.InstanceID, dd.Namespace, dd.Name)
+	provisionInfo.InstanceName = dd.Name
+	provisionInfo.origin = dd.Spec.Origin.DeepCopy()
+	return nil
+}
+
+// SetDormantGCLock sets the lock the DormantDatabases are collected under, so that a single replica of the
+// broker collects them at a time. The lock returns false, if it is held by another replica.
+func (c *Client) SetDormantGCLock(lock func() (func(), bool, error)) {
+	c.dormantGCLock = lock
+}
+
+// collectDormantDatabases wipes out and deletes the DormantDatabases of the paused instances those are
+// retained longer than the retention period.
+func (c *Client) collectDormantDatabases() {
+	if c.dormantGCLock != nil {
+		release, ok, err := c.dormantGCLock()
+		if err != nil {
+			glog.Errorf("Failed to lock the collection of DormantDatabases: %v", err)
+			return
+		} else if !ok {
+			glog.V(4).Infoln("DormantDatabases are being collected by another replica")
+			return
+		}
+		defer release()
+	}
+
+	for _, obj := range c.dormantInformer.GetStore().List() {
+		dd := obj.(*api.DormantDatabase)
+		if _, found := dd.Spec.Origin.Labels[InstanceKey]; !found {
+			continue
+		}
+		deprovisionedAt, found := dd.Spec.Origin.Annotations[DeprovisionedAtKey]
+		if !found {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, deprovisionedAt)
+		if err != nil {
+			glog.Errorf("Invalid deprovisioning time %q of DormantDatabase %s/%s: %v", deprovisionedAt, dd.Namespace, dd.Name, err)
+			continue
+		}
+		if time.Since(t) < c.dormantRetention {
+			continue
+		}
+
+		glog.Infof("Retention of DormantDatabase %s/%s has lapsed, wiping it out...", dd.Namespace, dd.Name)
+		if err := c.deleteDormantDatabase(dd.Name, dd.Namespace); err != nil {
+			glog.Errorf("Failed to delete DormantDatabase %s/%s: %v", dd.Namespace, dd.Name, err)
+			continue
+		}
+		if err := c.deleteParamsSecret(dd.Name, dd.Namespace); err != nil {
+			glog.Errorln(err)
+		}
+	}
+}
+
+// runDormantGC collects the DormantDatabases periodically, if a retention period is set.
+func (c *Client) runDormantGC(stopCh <-chan struct{}) {
+	if c.dormantRetention <= 0 {
+		return
+	}
+	glog.Infof("Paused instances are retained for %s", c.dormantRetention)
+	go wait.Until(c.collectDormantDatabases, dormantGCInterval, stopCh)
+}
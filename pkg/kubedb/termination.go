@@ -0,0 +1,118 @@
+package kubedb
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TerminationPolicyParam is the query parameter of a deprovision request that overrides the termination policy of the instance
+const TerminationPolicyParam = "termination_policy"
+
+var terminationPolicies = []api.TerminationPolicy{
+	api.TerminationPolicyPause,
+	api.TerminationPolicyDelete,
+	api.TerminationPolicyWipeOut,
+	api.TerminationPolicyDoNotTerminate,
+}
+
+// ParseTerminationPolicy returns the termination policy of the given name. The name is matched case-insensitively.
+// It returns an empty policy for an empty name, and 400 Bad Request for an unknown one.
+func ParseTerminationPolicy(name string) (api.TerminationPolicy, error) {
+	if name == "" {
+		return "", nil
+	}
+	for _, policy := range terminationPolicies {
+		if strings.EqualFold(name, string(policy)) {
+			return policy, nil
+		}
+	}
+	return "", badRequest("unknown termination policy %q, must be one of %s, %s, %s or %s", name,
+		api.TerminationPolicyPause, api.TerminationPolicyDelete, api.TerminationPolicyWipeOut, api.TerminationPolicyDoNotTerminate)
+}
+
+// SetTerminationPolicies sets the termination policies of the instances of the plans by their ids, those are
+// applied unless the provision params set the termination policy of an instance.
+func (c *Client) SetTerminationPolicies(policies map[string]string) error {
+	c.terminationPolicies = make(map[string]api.TerminationPolicy, len(policies))
+	for planID, name := range policies {
+		policy, err := ParseTerminationPolicy(name)
+		if err != nil {
+			return err
+		}
+		c.terminationPolicies[planID] = policy
+	}
+	return nil
+}
+
+// applyTerminationPolicy sets the termination policy of the plan of an instance, unless it is set by the params.
+func (p ProvisionInfo) applyTerminationPolicy(policy *api.TerminationPolicy) {
+	if p.terminationPolicy == "" {
+		return
+	}
+	if spec, ok := p.Params["spec"].(map[string]interface{}); ok {
+		if _, found := spec["terminationPolicy"]; found {
+			return
+		}
+	}
+	*policy = p.terminationPolicy
+}
+
+// deletionPolicy returns the termination policy a KubeDB object is deleted with: the requested policy if any,
+// otherwise the policy of the object. Objects those are protected from deletion are never deleted, an object
+// has to be updated with another termination policy first.
+func deletionPolicy(meta metav1.ObjectMeta, current, requested api.TerminationPolicy) (api.TerminationPolicy, error) {
+	policy := current
+	if requested != "" {
+		policy = requested
+	}
+	if policy == "" {
+		// default policy of KubeDB
+		policy = api.TerminationPolicyPause
+	}
+
+	if current == api.TerminationPolicyDoNotTerminate || policy == api.TerminationPolicyDoNotTerminate {
+		return "", statusError(http.StatusUnprocessableEntity, nil,
+			"%s/%s is protected from deletion by termination policy %s, update the instance with another spec.terminationPolicy to deprovision it",
+			meta.Namespace, meta.Name, api.TerminationPolicyDoNotTerminate)
+	}
+	return policy, nil
+}
+
+// prepareDeletion sets the termination policy a KubeDB object is deleted with. An object that is paused
+// records the time of its deprovisioning and its own termination policy, those are carried over to its
+// DormantDatabase.
+func prepareDeletion(meta *metav1.ObjectMeta, policy *api.TerminationPolicy, deletion api.TerminationPolicy) {
+	if deletion == api.TerminationPolicyPause {
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		meta.Annotations[DeprovisionedAtKey] = time.Now().UTC().Format(time.RFC3339)
+		if _, found := meta.Annotations[PausedTerminationPolicyKey]; !found && *policy != "" {
+			meta.Annotations[PausedTerminationPolicyKey] = string(*policy)
+		}
+	}
+	*policy = deletion
+}
+
+// resumeTerminationPolicy sets the termination policy of an instance that resumes a paused instance, whose
+// spec is copied from its origin along with the Pause policy it is deleted with: the policy of the params or
+// of the plan if any, otherwise the policy the paused instance had before it is deprovisioned.
+func (p ProvisionInfo) resumeTerminationPolicy(policy *api.TerminationPolicy) {
+	if p.origin == nil {
+		return
+	}
+	if paused, found := p.origin.Annotations[PausedTerminationPolicyKey]; found {
+		*policy = api.TerminationPolicy(paused)
+	}
+	if spec, ok := p.Params["spec"].(map[string]interface{}); ok {
+		if v, ok := spec["terminationPolicy"].(string); ok && v != "" {
+			*policy = api.TerminationPolicy(v)
+			return
+		}
+	}
+	p.applyTerminationPolicy(policy)
+}
@@ -0,0 +1,112 @@
+package kubedb
+
+import (
+	"net/http"
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeletionPolicy(t *testing.T) {
+	cases := []struct {
+		name      string
+		current   api.TerminationPolicy
+		requested api.TerminationPolicy
+		expected  api.TerminationPolicy
+		// expected status code of the error, zero if the object is deleted
+		code int
+	}{
+		{name: "own policy", current: api.TerminationPolicyDelete, expected: api.TerminationPolicyDelete},
+		{name: "requested policy", current: api.TerminationPolicyPause, requested: api.TerminationPolicyWipeOut, expected: api.TerminationPolicyWipeOut},
+		{name: "default policy", expected: api.TerminationPolicyPause},
+		{name: "protected", current: api.TerminationPolicyDoNotTerminate, code: http.StatusUnprocessableEntity},
+		{
+			name:      "protected with a requested policy",
+			current:   api.TerminationPolicyDoNotTerminate,
+			requested: api.TerminationPolicyWipeOut,
+			code:      http.StatusUnprocessableEntity,
+		},
+		{
+			name:      "protection requested",
+			current:   api.TerminationPolicyDelete,
+			requested: api.TerminationPolicyDoNotTerminate,
+			code:      http.StatusUnprocessableEntity,
+		},
+	}
+
+	meta := metav1.ObjectMeta{Name: "db", Namespace: "default"}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy, err := deletionPolicy(meta, c.current, c.requested)
+			if c.code == 0 {
+				if err != nil {
+					t.Fatalf("expected object to be deleted, found %v", err)
+				}
+				if policy != c.expected {
+					t.Errorf("expected policy %q, found %q", c.expected, policy)
+				}
+				return
+			}
+			if e, ok := osb.IsHTTPError(err); !ok || e.StatusCode != c.code {
+				t.Errorf("expected error with status code %d, found %v", c.code, err)
+			}
+		})
+	}
+}
+
+func TestParseTerminationPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected api.TerminationPolicy
+		valid    bool
+	}{
+		{name: "", valid: true},
+		{name: "Pause", expected: api.TerminationPolicyPause, valid: true},
+		{name: "wipeout", expected: api.TerminationPolicyWipeOut, valid: true},
+		{name: "DONOTTERMINATE", expected: api.TerminationPolicyDoNotTerminate, valid: true},
+		{name: "Keep"},
+	}
+
+	for _, c := range cases {
+		policy, err := ParseTerminationPolicy(c.name)
+		if c.valid {
+			if err != nil || policy != c.expected {
+				t.Errorf("expected policy %q of %q, found %q, %v", c.expected, c.name, policy, err)
+			}
+			continue
+		}
+		if e, ok := osb.IsHTTPError(err); !ok || e.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected error with status code %d for %q, found %v", http.StatusBadRequest, c.name, err)
+		}
+	}
+}
+
+func TestApplyTerminationPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		plan     api.TerminationPolicy
+		params   string
+		expected api.TerminationPolicy
+	}{
+		{name: "policy of the plan", plan: api.TerminationPolicyDoNotTerminate, params: `{}`, expected: api.TerminationPolicyDoNotTerminate},
+		{
+			name:     "policy of the params",
+			plan:     api.TerminationPolicyDoNotTerminate,
+			params:   `{"spec":{"terminationPolicy":"Delete"}}`,
+			expected: api.TerminationPolicyWipeOut,
+		},
+		{name: "no policy of the plan", params: `{}`, expected: api.TerminationPolicyWipeOut},
+	}
+
+	for _, c := range cases {
+		// the policy of the spec template, or of the params if they set it
+		policy := api.TerminationPolicyWipeOut
+		p := ProvisionInfo{Params: params(t, c.params), terminationPolicy: c.plan}
+		p.applyTerminationPolicy(&policy)
+		if policy != c.expected {
+			t.Errorf("%s: expected policy %q, found %q", c.name, c.expected, policy)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package kubedb
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MergeParams applies the parameters of an update request on the parameters an instance is
+// provisioned with, following the semantics of a JSON merge patch (RFC 7386).
+func MergeParams(params, update map[string]interface{}) (map[string]interface{}, error) {
+	if len(update) == 0 {
+		return params, nil
+	}
+
+	// work on a copy, so that the params in use are left untouched
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	return mergePatch(merged, update), nil
+}
+
+func mergePatch(doc, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		if p, ok := v.(map[string]interface{}); ok {
+			d, _ := doc[k].(map[string]interface{})
+			doc[k] = mergePatch(d, p)
+			continue
+		}
+		doc[k] = v
+	}
+	return doc
+}
+
+// mergeSpecUpdate applies the spec parameter on the current spec of a database and stores the
+// result in out, which must point to a zero value spec of the same type. Changes to fields other
+// than version upgrades, replicas, pod resources, storage size, monitoring and the termination policy
+// are rejected with 400.
+//
+// If the plan is changed, the fields of the spec template of the previous plan are replaced with the
+// ones of the new plan first, which may also change the topology of the database. The termination policy
+// of the database is kept though, as the templates set one for new instances. A plan can only be changed
+// to a plan with a spec template.
+func (p ProvisionInfo) mergeSpecUpdate(cur interface{}, out interface{}) error {
+	curJson, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+
+	// the current spec is decoded twice, as merging modifies the document
+	var curSpec, doc map[string]interface{}
+	if err := json.Unmarshal(curJson, &curSpec); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(curJson, &doc); err != nil {
+		return err
+	}
+
+	planChanged := p.prevPlan != nil && p.plan != nil && p.prevPlan.ID != p.plan.ID
+	if planChanged {
+		template, err := p.plan.specTemplate()
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			return planUpdateNotSupported(p.prevPlan.ID, p.plan.ID)
+		}
+		for field := range p.prevPlan.Spec {
+			delete(doc, field)
+		}
+		for field, value := range template {
+			doc[field] = value
+		}
+		// the termination policy of the instance is kept, it is only changed by the params
+		if policy, found := curSpec["terminationPolicy"]; found {
+			doc["terminationPolicy"] = policy
+		} else {
+			delete(doc, "terminationPolicy")
+		}
+	}
+	if spec, found := p.Params["spec"]; found {
+		update, ok := spec.(map[string]interface{})
+		if !ok {
+			return badRequest("spec must be an object")
+		}
+		doc = mergePatch(doc, update)
+	}
+
+	mergedJson, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(mergedJson, out); err != nil {
+		return badRequest("invalid spec: %v", err)
+	}
+
+	// compare both the specs in their normalized form
+	outJson, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	var outSpec map[string]interface{}
+	if err := json.Unmarshal(outJson, &outSpec); err != nil {
+		return err
+	}
+	return validateSpecUpdate(curSpec, outSpec, planChanged)
+}
+
+func validateSpecUpdate(cur, mod map[string]interface{}, planChanged bool) error {
+	fields := make([]string, 0, len(cur))
+	for field := range cur {
+		fields = append(fields, field)
+	}
+	for field := range mod {
+		if _, found := cur[field]; !found {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if reflect.DeepEqual(cur[field], mod[field]) {
+			continue
+		}
+
+		switch field {
+		case "replicas", "monitor":
+		case "terminationPolicy":
+			// a protected instance is deprovisioned by updating its termination policy first
+			policy, _, _ := unstructured.NestedString(mod, field)
+			if parsed, err := ParseTerminationPolicy(policy); err != nil {
+				return err
+			} else if string(parsed) != policy {
+				return badRequest("spec.terminationPolicy must be %s", parsed)
+			}
+		case "version":
+			curVersion, _, _ := unstructured.NestedString(cur, field)
+			modVersion, _, _ := unstructured.NestedString(mod, field)
+			if compareVersions(modVersion, curVersion) < 0 {
+				return badRequest("spec.version can not be downgraded from %q to %q", curVersion, modVersion)
+			}
+		case "podTemplate":
+			unstructured.RemoveNestedField(cur, field, "spec", "resources")
+			unstructured.RemoveNestedField(mod, field, "spec", "resources")
+			if !reflect.DeepEqual(cur[field], mod[field]) {
+				return badRequest("only spec.podTemplate.spec.resources can be updated")
+			}
+		case "storage":
+			if err := validateStorageUpdate(cur, mod); err != nil {
+				return err
+			}
+		case "topology", "replicaSet":
+			// the topology is changed only along with the plan
+			if !planChanged {
+				return badRequest("spec.%s can not be updated", field)
+			}
+		default:
+			return badRequest("spec.%s can not be updated", field)
+		}
+	}
+	return nil
+}
+
+// validateStorageUpdate allows to increase the requested storage size, nothing else of the storage can be changed.
+func validateStorageUpdate(cur, mod map[string]interface{}) error {
+	if cur["storage"] == nil || mod["storage"] == nil {
+		return badRequest("spec.storage can not be added or removed")
+	}
+
+	curSize, _, _ := unstructured.NestedString(cur, "storage", "resources", "requests", "storage")
+	modSize, _, _ := unstructured.NestedString(mod, "storage", "resources", "requests", "storage")
+	if curSize != modSize {
+		curQuantity, err := resource.ParseQuantity(curSize)
+		if err != nil {
+			return badRequest("invalid storage size %q of the instance: %v", curSize, err)
+		}
+		modQuantity, err := resource.ParseQuantity(modSize)
+		if err != nil {
+			return badRequest("invalid storage size %q: %v", modSize, err)
+		}
+		if modQuantity.Cmp(curQuantity) < 0 {
+			return badRequest("spec.storage can not be shrunk from %s to %s", curSize, modSize)
+		}
+	}
+
+	unstructured.RemoveNestedField(cur, "storage", "resources", "requests", "storage")
+	unstructured.RemoveNestedField(mod, "storage", "resources", "requests", "storage")
+	if !reflect.DeepEqual(cur["storage"], mod["storage"]) {
+		return badRequest("only spec.storage.resources.requests.storage can be updated")
+	}
+	return nil
+}
+
+var reVersionPart = regexp.MustCompile(`\d+`)
+
+// compareVersions compares KubeDB version names (e.g. 9.6-v4, 10.2-v2) by their numeric parts.
+func compareVersions(a, b string) int {
+	x := reVersionPart.FindAllString(a, -1)
+	y := reVersionPart.FindAllString(b, -1)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.Atoi(x[i])
+		n, _ := strconv.Atoi(y[i])
+		if m != n {
+			if m < n {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(x) - len(y)
+}
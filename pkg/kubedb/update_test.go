@@ -0,0 +1,267 @@
+package kubedb
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func TestMergePatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		doc    string
+		patch  string
+		merged string
+	}{
+		{
+			name:   "nested fields",
+			doc:    `{"spec":{"version":"10.2-v2","replicas":1}}`,
+			patch:  `{"spec":{"replicas":3}}`,
+			merged: `{"spec":{"version":"10.2-v2","replicas":3}}`,
+		},
+		{
+			name:   "null removes a field",
+			doc:    `{"spec":{"version":"10.2-v2","monitor":{"agent":"prometheus.io/builtin"}}}`,
+			patch:  `{"spec":{"monitor":null}}`,
+			merged: `{"spec":{"version":"10.2-v2"}}`,
+		},
+		{
+			name:   "arrays are replaced",
+			doc:    `{"users":[{"name":"a"},{"name":"b"}]}`,
+			patch:  `{"users":[{"name":"c"}]}`,
+			merged: `{"users":[{"name":"c"}]}`,
+		},
+		{
+			name:   "object replaces a value",
+			doc:    `{"spec":"invalid"}`,
+			patch:  `{"spec":{"replicas":3}}`,
+			merged: `{"spec":{"replicas":3}}`,
+		},
+		{
+			name:   "empty document",
+			patch:  `{"spec":{"replicas":3,"monitor":null}}`,
+			merged: `{"spec":{"replicas":3}}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var doc map[string]interface{}
+			if c.doc != "" {
+				doc = params(t, c.doc)
+			}
+			merged := mergePatch(doc, params(t, c.patch))
+			if expected := params(t, c.merged); !reflect.DeepEqual(merged, expected) {
+				t.Errorf("expected %v, found %v", expected, merged)
+			}
+		})
+	}
+}
+
+func TestValidateSpecUpdate(t *testing.T) {
+	cur := `{
+		"version": "10.2-v2",
+		"replicas": 1,
+		"terminationPolicy": "Pause",
+		"storageType": "Durable",
+		"storage": {"storageClassName": "standard", "resources": {"requests": {"storage": "1Gi"}}},
+		"podTemplate": {"spec": {"resources": {"requests": {"cpu": "100m"}}, "nodeSelector": {"disk": "ssd"}}},
+		"topology": {"mode": "Dedicated"}
+	}`
+
+	cases := []struct {
+		name        string
+		update      string
+		planChanged bool
+		// expected status code of the error, zero if the update is valid
+		code int
+	}{
+		{name: "no change", update: `{}`},
+		{name: "replicas", update: `{"replicas":3}`},
+		{name: "monitoring", update: `{"monitor":{"agent":"prometheus.io/builtin"}}`},
+		{name: "version upgrade", update: `{"version":"10.6-v1"}`},
+		{name: "version downgrade", update: `{"version":"9.6-v4"}`, code: http.StatusBadRequest},
+		{name: "pod resources", update: `{"podTemplate":{"spec":{"resources":{"requests":{"cpu":"1"}}}}}`},
+		{name: "pod node selector", update: `{"podTemplate":{"spec":{"nodeSelector":{"disk":"hdd"}}}}`, code: http.StatusBadRequest},
+		{name: "storage growth", update: `{"storage":{"resources":{"requests":{"storage":"2Gi"}}}}`},
+		{name: "storage shrink", update: `{"storage":{"resources":{"requests":{"storage":"512Mi"}}}}`, code: http.StatusBadRequest},
+		{name: "storage class", update: `{"storage":{"storageClassName":"fast"}}`, code: http.StatusBadRequest},
+		{name: "storage removed", update: `{"storage":null}`, code: http.StatusBadRequest},
+		{name: "termination policy", update: `{"terminationPolicy":"WipeOut"}`},
+		{name: "unknown termination policy", update: `{"terminationPolicy":"Keep"}`, code: http.StatusBadRequest},
+		{name: "termination policy in another case", update: `{"terminationPolicy":"wipeout"}`, code: http.StatusBadRequest},
+		{name: "topology", update: `{"topology":{"mode":"Combined"}}`, code: http.StatusBadRequest},
+		{name: "topology along with the plan", update: `{"topology":{"mode":"Combined"}}`, planChanged: true},
+		{name: "storage type", update: `{"storageType":"Ephemeral"}`, code: http.StatusBadRequest},
+		{name: "new field", update: `{"init":{"scriptSource":{"configMap":{"name":"init"}}}}`, code: http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mod := mergePatch(params(t, cur), params(t, c.update))
+			err := validateSpecUpdate(params(t, cur), mod, c.planChanged)
+			if c.code == 0 {
+				if err != nil {
+					t.Errorf("expected update to be valid, found %v", err)
+				}
+				return
+			}
+			if e, ok := osb.IsHTTPError(err); !ok || e.StatusCode != c.code {
+				t.Errorf("expected error with status code %d, found %v", c.code, err)
+			}
+		})
+	}
+}
+
+func TestMergeSpecUpdatePlanChange(t *testing.T) {
+	prevPlan := &Plan{ID: "small", Spec: params(t, `{"replicas":1,"terminationPolicy":"WipeOut"}`)}
+	plan := &Plan{ID: "large", Spec: params(t, `{"replicas":3,"terminationPolicy":"WipeOut"}`)}
+
+	cases := []struct {
+		name     string
+		cur      string
+		params   string
+		replicas int32
+		policy   api.TerminationPolicy
+	}{
+		{
+			name:     "policy kept",
+			cur:      `{"version":"10.2-v2","replicas":1,"terminationPolicy":"DoNotTerminate"}`,
+			params:   `{}`,
+			replicas: 3,
+			policy:   api.TerminationPolicyDoNotTerminate,
+		},
+		{
+			name:     "policy updated by the params",
+			cur:      `{"version":"10.2-v2","replicas":1,"terminationPolicy":"DoNotTerminate"}`,
+			params:   `{"spec":{"terminationPolicy":"Delete"}}`,
+			replicas: 3,
+			policy:   api.TerminationPolicyDelete,
+		},
+		{
+			name:     "no policy",
+			cur:      `{"version":"10.2-v2","replicas":1}`,
+			params:   `{}`,
+			replicas: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var cur api.PostgresSpec
+			if err := json.Unmarshal([]byte(c.cur), &cur); err != nil {
+				t.Fatal(err)
+			}
+			p := ProvisionInfo{Params: params(t, c.params), plan: plan, prevPlan: prevPlan}
+			var out api.PostgresSpec
+			if err := p.mergeSpecUpdate(cur, &out); err != nil {
+				t.Fatalf("expected update to be valid, found %v", err)
+			}
+			if out.Replicas == nil || *out.Replicas != c.replicas {
+				t.Errorf("expected %d replicas, found %v", c.replicas, out.Replicas)
+			}
+			if out.TerminationPolicy != c.policy {
+				t.Errorf("expected termination policy %q, found %q", c.policy, out.TerminationPolicy)
+			}
+		})
+	}
+}
+
+func TestValidateStorageUpdate(t *testing.T) {
+	cases := []struct {
+		name  string
+		cur   string
+		mod   string
+		valid bool
+	}{
+		{
+			name:  "same size",
+			cur:   `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+			mod:   `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+			valid: true,
+		},
+		{
+			name:  "same size in other units",
+			cur:   `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+			mod:   `{"storage":{"resources":{"requests":{"storage":"1024Mi"}}}}`,
+			valid: true,
+		},
+		{
+			name:  "growth",
+			cur:   `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+			mod:   `{"storage":{"resources":{"requests":{"storage":"10Gi"}}}}`,
+			valid: true,
+		},
+		{
+			name: "shrink",
+			cur:  `{"storage":{"resources":{"requests":{"storage":"10Gi"}}}}`,
+			mod:  `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+		},
+		{
+			name: "invalid size",
+			cur:  `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+			mod:  `{"storage":{"resources":{"requests":{"storage":"large"}}}}`,
+		},
+		{
+			name: "access modes",
+			cur:  `{"storage":{"accessModes":["ReadWriteOnce"],"resources":{"requests":{"storage":"1Gi"}}}}`,
+			mod:  `{"storage":{"accessModes":["ReadWriteMany"],"resources":{"requests":{"storage":"1Gi"}}}}`,
+		},
+		{
+			name: "size added",
+			cur:  `{"storage":{"storageClassName":"standard"}}`,
+			mod:  `{"storage":{"storageClassName":"standard","resources":{"requests":{"storage":"1Gi"}}}}`,
+		},
+		{
+			name: "added",
+			cur:  `{}`,
+			mod:  `{"storage":{"resources":{"requests":{"storage":"1Gi"}}}}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateStorageUpdate(params(t, c.cur), params(t, c.mod))
+			if c.valid && err != nil {
+				t.Errorf("expected update to be valid, found %v", err)
+			}
+			if e, ok := osb.IsHTTPError(err); !c.valid && (!ok || e.StatusCode != http.StatusBadRequest) {
+				t.Errorf("expected error with status code %d, found %v", http.StatusBadRequest, err)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "10.2-v2", b: "10.2-v2", expected: 0},
+		{a: "10.2-v2", b: "9.6-v4", expected: 1},
+		{a: "9.6-v4", b: "10.2-v2", expected: -1},
+		{a: "10.2-v1", b: "10.2-v2", expected: -1},
+		{a: "5.7.25", b: "5.7", expected: 1},
+		{a: "6.3-v1", b: "6.3-v1.1", expected: -1},
+	}
+
+	for _, c := range cases {
+		if n := compareVersions(c.a, c.b); sign(n) != c.expected {
+			t.Errorf("expected comparison of %q with %q to be %d, found %d", c.a, c.b, c.expected, n)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,299 @@
+package kubedb
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/appscode/go/crypto/rand"
+	"github.com/appscode/go/types"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kutil "kmodules.xyz/client-go"
+	mu "kmodules.xyz/client-go/meta"
+	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+// BindingRole is the role of the database user that is created for a binding.
+type BindingRole string
+
+const (
+	BindingRoleOwner     BindingRole = "owner"
+	BindingRoleReadWrite BindingRole = "read-write"
+	BindingRoleReadOnly  BindingRole = "read-only"
+
+	// Bind parameter to choose the role of the binding user
+	bindingRoleParam = "role"
+
+	// Maximum time to wait for the Job that creates a binding user
+	userJobTimeout = 2 * time.Minute
+
+	// Environment variables passed to the scripts those manage the binding users
+	envDBHost        = "DB_HOST"
+	envDBPort        = "DB_PORT"
+	envAdminUsername = "ADMIN_USERNAME"
+	envAdminPassword = "ADMIN_PASSWORD"
+	envUsername      = "USERNAME"
+	envPassword      = "PASSWORD"
+
+	keyBindingRole = "role"
+)
+
+func bindingRole(params map[string]interface{}) (BindingRole, error) {
+	v, found := params[bindingRoleParam]
+	if !found {
+		return BindingRoleReadWrite, nil
+	}
+
+	role, _ := v.(string)
+	switch BindingRole(role) {
+	case BindingRoleOwner, BindingRoleReadWrite, BindingRoleReadOnly:
+		return BindingRole(role), nil
+	}
+	return "", badRequest("invalid role %v, supported roles are %q, %q and %q",
+		v, BindingRoleOwner, BindingRoleReadWrite, BindingRoleReadOnly)
+}
+
+// userProvider is implemented by the providers those create a database user for every binding.
+// The scripts run in a Job with the client image of the database. The address of the database,
+// the admin credentials and the credentials of the binding user are passed as environment variables.
+type userProvider interface {
+	createUserScript(role BindingRole) string
+	dropUserScript() string
+}
+
+// SetClientImages sets the client images of the databases, those the users of the bindings are managed with.
+func (c *Client) SetClientImages(mongoDB, mySQL, postgres string) {
+	c.clientImages = map[string]string{
+		KubeDBServiceMongoDB:    mongoDB,
+		KubeDBServiceMySQL:      mySQL,
+		KubeDBServicePostgreSQL: postgres,
+	}
+}
+
+func bindingHash(bindingID string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(bindingID)))[:16]
+}
+
+// bindingName returns the name of the Secret that records a binding.
+func bindingName(bindingID string) string {
+	return "osb-binding-" + bindingHash(bindingID)
+}
+
+func bindingUsername(bindingID string) string {
+	return "osb_" + bindingHash(bindingID)
+}
+
+// secretKey returns the key of the AppBinding secret that ends up as the given
+// credential key, following the rename transforms backwards.
+func secretKey(app *appcat.AppBinding, key string) string {
+	for i := len(app.Spec.SecretTransforms) - 1; i >= 0; i-- {
+		if t := app.Spec.SecretTransforms[i].RenameKey; t != nil && t.To == key {
+			key = t.From
+		}
+	}
+	return key
+}
+
+func bindingObjectMeta(app *appcat.AppBinding, name, instanceID, bindingID string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: app.Namespace,
+		Labels: map[string]string{
+			InstanceKey:          instanceID,
+			mu.ManagedByLabelKey: "appscode-service-broker",
+		},
+		// objects of a binding are garbage collected along with the instance
+		OwnerReferences: []metav1.OwnerReference{
+			{
+				APIVersion: appcat.SchemeGroupVersion.String(),
+				Kind:       appcat.ResourceKindApp,
+				Name:       app.Name,
+				UID:        app.UID,
+			},
+		},
+	}
+	if len(validation.IsValidLabelValue(bindingID)) == 0 {
+		meta.Labels[BindingKey] = bindingID
+	}
+	return meta
+}
+
+// bindingOf reports whether the Secret of a binding records a binding of the given instance. The Secrets are
+// named after the binding ids only, so a binding of another instance in the same namespace is told apart by its label.
+func bindingOf(secret *core.Secret, instanceID string) bool {
+	return secret.Labels[InstanceKey] == instanceID
+}
+
+// ensureBindingSecret creates the Secret that records a binding. If withUser is set, the Secret
+// also holds the generated credentials of the binding user. The Secret of an existing binding is reused.
+// It returns 409 Conflict, if the binding exists for another instance or with another role.
+func (c *Client) ensureBindingSecret(app *appcat.AppBinding, instanceID, bindingID string, role BindingRole, withUser bool) (*core.Secret, error) {
+	name := bindingName(bindingID)
+	secret, err := c.kubeClient.CoreV1().Secrets(app.Namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		secret = &core.Secret{
+			ObjectMeta: bindingObjectMeta(app, name, instanceID, bindingID),
+			StringData: map[string]string{
+				keyBindingRole: string(role),
+			},
+		}
+		if withUser {
+			secret.StringData[appcat.KeyUsername] = bindingUsername(bindingID)
+			secret.StringData[appcat.KeyPassword] = rand.GeneratePassword()
+		}
+		glog.Infof("Creating secret %s/%s for binding %q...", secret.Namespace, secret.Name, bindingID)
+		return c.kubeClient.CoreV1().Secrets(app.Namespace).Create(secret)
+	} else if err != nil {
+		return nil, err
+	} else if !bindingOf(secret, instanceID) {
+		return nil, conflict("binding %q already exists for another instance", bindingID)
+	} else if string(secret.Data[keyBindingRole]) != string(role) {
+		return nil, conflict("binding %q already exists with role %q", bindingID, secret.Data[keyBindingRole])
+	}
+	return secret, nil
+}
+
+// createUserJob creates the Job that runs a script to manage the user of a binding in the given image.
+// The Job is named after the Secret of the binding with the given suffix.
+func (c *Client) createUserJob(image string, app *appcat.AppBinding, instanceID, bindingID, suffix, script string) error {
+	if app.Spec.Secret == nil {
+		return errors.Errorf("no secret found in AppBinding %s/%s", app.Namespace, app.Name)
+	}
+	if app.Spec.ClientConfig.Service == nil {
+		return errors.Errorf("no service found in AppBinding %s/%s", app.Namespace, app.Name)
+	}
+
+	name := bindingName(bindingID)
+	job := &batch.Job{
+		ObjectMeta: bindingObjectMeta(app, name+"-"+suffix, instanceID, bindingID),
+		Spec: batch.JobSpec{
+			BackoffLimit: types.Int32P(5),
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					RestartPolicy: core.RestartPolicyNever,
+					Containers: []core.Container{
+						{
+							Name:    "user",
+							Image:   image,
+							Command: []string{"sh", "-c", script},
+							Env:     userJobEnv(app, name),
+						},
+					},
+				},
+			},
+		},
+	}
+	_, err := c.kubeClient.BatchV1().Jobs(job.Namespace).Create(job)
+	if !kerr.IsAlreadyExists(err) {
+		return err
+	}
+
+	// the Job of an earlier attempt is reused, unless it has failed
+	cur, err := c.kubeClient.BatchV1().Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		_, err = c.kubeClient.BatchV1().Jobs(job.Namespace).Create(job)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if !jobFailed(cur) {
+		return nil
+	}
+	glog.Infof("Recreating failed job %s/%s of binding %q...", job.Namespace, job.Name, bindingID)
+	policy := metav1.DeletePropagationBackground
+	err = c.kubeClient.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	_, err = c.kubeClient.BatchV1().Jobs(job.Namespace).Create(job)
+	return err
+}
+
+func jobFailed(job *batch.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBindingObjects deletes the Secret and the Jobs of a binding.
+func (c *Client) deleteBindingObjects(namespace, bindingID string) error {
+	name := bindingName(bindingID)
+	policy := metav1.DeletePropagationBackground
+	for _, job := range []string{name + "-create", name + "-drop"} {
+		err := c.kubeClient.BatchV1().Jobs(namespace).Delete(job, &metav1.DeleteOptions{PropagationPolicy: &policy})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+
+	glog.Infof("Deleting secret %s/%s of binding %q...", namespace, name, bindingID)
+	err := c.kubeClient.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func userJobEnv(app *appcat.AppBinding, bindingSecret string) []core.EnvVar {
+	secretEnv := func(name, secret, key string) core.EnvVar {
+		return core.EnvVar{
+			Name: name,
+			ValueFrom: &core.EnvVarSource{
+				SecretKeyRef: &core.SecretKeySelector{
+					LocalObjectReference: core.LocalObjectReference{Name: secret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	svc := app.Spec.ClientConfig.Service
+	return []core.EnvVar{
+		{Name: envDBHost, Value: fmt.Sprintf("%s.%s.svc", svc.Name, app.Namespace)},
+		{Name: envDBPort, Value: strconv.Itoa(int(svc.Port))},
+		secretEnv(envAdminUsername, app.Spec.Secret.Name, secretKey(app, appcat.KeyUsername)),
+		secretEnv(envAdminPassword, app.Spec.Secret.Name, secretKey(app, appcat.KeyPassword)),
+		secretEnv(envUsername, bindingSecret, appcat.KeyUsername),
+		secretEnv(envPassword, bindingSecret, appcat.KeyPassword),
+	}
+}
+
+// waitForUserJob waits for the Job of a binding user to complete and removes it afterwards.
+// A failed Job is left in place, so that its pods can be inspected, until the binding is retried.
+func (c *Client) waitForUserJob(namespace, name string, timeout time.Duration) error {
+	err := wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
+		job, err := c.kubeClient.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, cond := range job.Status.Conditions {
+			if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue {
+				return false, errors.Errorf("job %s/%s has failed: %s", namespace, name, cond.Message)
+			}
+		}
+		return job.Status.Succeeded > 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("job %s/%s is not complete after %s", namespace, name, timeout)
+	} else if err != nil {
+		return err
+	}
+
+	policy := metav1.DeletePropagationBackground
+	err = c.kubeClient.BatchV1().Jobs(namespace).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !kerr.IsNotFound(err) {
+		glog.Errorf("failed to delete job %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
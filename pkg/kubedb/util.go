@@ -1,14 +1,29 @@
 package kubedb
 
 import (
+	"time"
+
+	"github.com/appscode/go/encoding/json/types"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1"
 	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"github.com/pkg/errors"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kutil "kmodules.xyz/client-go"
 )
 
+// observedPhase returns the phase of a KubeDB object, or an empty phase if the operator has not observed
+// the latest generation of the object yet, e.g. right after it is updated, as the phase is stale then.
+// The phase of an object is taken as is, if the operator does not record the observed generation.
+func observedPhase(meta metav1.ObjectMeta, phase api.DatabasePhase, observed *types.IntHash) api.DatabasePhase {
+	if observed != nil && observed.Generation() < meta.Generation {
+		return ""
+	}
+	return phase
+}
+
 var (
 	WaitForMySQLBeReady         = waitForMySQLBeReady
 	WaitForPostgreSQLBeReady    = waitForPostgreSQLBeReady
@@ -18,62 +33,80 @@ var (
 	WaitForMemcachedBeReady     = waitForMemcachedBeReady
 )
 
-func waitForMemcachedBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
+func waitForMemcachedBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string, timeout time.Duration) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
 		mc, err := extClient.Memcacheds(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, nil
 		}
+		if mc.Status.Phase == api.DatabasePhaseFailed {
+			return false, errors.Errorf("memcached %s/%s has failed: %s", namespace, name, mc.Status.Reason)
+		}
 		return mc.Status.Phase == api.DatabasePhaseRunning, nil
 	})
 }
 
-func waitForRedisBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
+func waitForRedisBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string, timeout time.Duration) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
 		rd, err := extClient.Redises(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, nil
 		}
+		if rd.Status.Phase == api.DatabasePhaseFailed {
+			return false, errors.Errorf("redis %s/%s has failed: %s", namespace, name, rd.Status.Reason)
+		}
 		return rd.Status.Phase == api.DatabasePhaseRunning, nil
 	})
 }
 
-func waitForMongoDbBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
+func waitForMongoDbBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string, timeout time.Duration) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
 		mg, err := extClient.MongoDBs(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, nil
 		}
+		if mg.Status.Phase == api.DatabasePhaseFailed {
+			return false, errors.Errorf("mongodb %s/%s has failed: %s", namespace, name, mg.Status.Reason)
+		}
 		return mg.Status.Phase == api.DatabasePhaseRunning, nil
 	})
 }
 
-func waitForElasticsearchBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
+func waitForElasticsearchBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string, timeout time.Duration) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
 		es, err := extClient.Elasticsearches(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, nil
 		}
+		if es.Status.Phase == api.DatabasePhaseFailed {
+			return false, errors.Errorf("elasticsearch %s/%s has failed: %s", namespace, name, es.Status.Reason)
+		}
 		return es.Status.Phase == api.DatabasePhaseRunning, nil
 	})
 }
 
-func waitForPostgreSQLBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
+func waitForPostgreSQLBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string, timeout time.Duration) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
 		pgsql, err := extClient.Postgreses(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, nil
 		}
+		if pgsql.Status.Phase == api.DatabasePhaseFailed {
+			return false, errors.Errorf("postgres %s/%s has failed: %s", namespace, name, pgsql.Status.Reason)
+		}
 		return pgsql.Status.Phase == api.DatabasePhaseRunning, nil
 	})
 }
 
-func waitForMySQLBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string) error {
-	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
+func waitForMySQLBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace string, timeout time.Duration) error {
+	return wait.PollImmediate(kutil.RetryInterval, timeout, func() (bool, error) {
 		mysql, err := extClient.MySQLs(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, nil
 		}
+		if mysql.Status.Phase == api.DatabasePhaseFailed {
+			return false, errors.Errorf("mysql %s/%s has failed: %s", namespace, name, mysql.Status.Reason)
+		}
 		return mysql.Status.Phase == api.DatabasePhaseRunning, nil
 	})
 }
@@ -81,6 +114,9 @@ func waitForMySQLBeReady(extClient cs.KubedbV1alpha1Interface, name, namespace s
 func patchRedis(extClient cs.KubedbV1alpha1Interface, rd *api.Redis, transform func(*api.Redis) *api.Redis) error {
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
 		if _, _, err := util.PatchRedis(extClient, rd, transform); err != nil {
+			if kerr.IsInvalid(err) || kutil.AdmissionWebhookDeniedRequest(err) {
+				return false, err
+			}
 			return false, nil
 		}
 
@@ -91,6 +127,9 @@ func patchRedis(extClient cs.KubedbV1alpha1Interface, rd *api.Redis, transform f
 func patchMemcached(extClient cs.KubedbV1alpha1Interface, mc *api.Memcached, transform func(*api.Memcached) *api.Memcached) error {
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
 		if _, _, err := util.PatchMemcached(extClient, mc, transform); err != nil {
+			if kerr.IsInvalid(err) || kutil.AdmissionWebhookDeniedRequest(err) {
+				return false, err
+			}
 			return false, nil
 		}
 
@@ -101,6 +140,9 @@ func patchMemcached(extClient cs.KubedbV1alpha1Interface, mc *api.Memcached, tra
 func patchMongoDb(extClient cs.KubedbV1alpha1Interface, mg *api.MongoDB, transform func(*api.MongoDB) *api.MongoDB) error {
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
 		if _, _, err := util.PatchMongoDB(extClient, mg, transform); err != nil {
+			if kerr.IsInvalid(err) || kutil.AdmissionWebhookDeniedRequest(err) {
+				return false, err
+			}
 			return false, nil
 		}
 
@@ -111,6 +153,9 @@ func patchMongoDb(extClient cs.KubedbV1alpha1Interface, mg *api.MongoDB, transfo
 func patchElasticsearch(extClient cs.KubedbV1alpha1Interface, es *api.Elasticsearch, transform func(*api.Elasticsearch) *api.Elasticsearch) error {
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
 		if _, _, err := util.PatchElasticsearch(extClient, es, transform); err != nil {
+			if kerr.IsInvalid(err) || kutil.AdmissionWebhookDeniedRequest(err) {
+				return false, err
+			}
 			return false, nil
 		}
 
@@ -121,6 +166,9 @@ func patchElasticsearch(extClient cs.KubedbV1alpha1Interface, es *api.Elasticsea
 func patchPostgreSQL(extClient cs.KubedbV1alpha1Interface, pgsql *api.Postgres, transform func(*api.Postgres) *api.Postgres) error {
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
 		if _, _, err := util.PatchPostgres(extClient, pgsql, transform); err != nil {
+			if kerr.IsInvalid(err) || kutil.AdmissionWebhookDeniedRequest(err) {
+				return false, err
+			}
 			return false, nil
 		}
 
@@ -131,6 +179,9 @@ func patchPostgreSQL(extClient cs.KubedbV1alpha1Interface, pgsql *api.Postgres,
 func patchMySQL(extClient cs.KubedbV1alpha1Interface, mysql *api.MySQL, transform func(*api.MySQL) *api.MySQL) error {
 	return wait.PollImmediate(kutil.RetryInterval, kutil.ReadinessTimeout, func() (bool, error) {
 		if _, _, err := util.PatchMySQL(extClient, mysql, transform); err != nil {
+			if kerr.IsInvalid(err) || kutil.AdmissionWebhookDeniedRequest(err) {
+				return false, err
+			}
 			return false, nil
 		}
 
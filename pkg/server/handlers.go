@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/appscode/service-broker/pkg/broker"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	libbroker "github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+)
+
+// apiHandlers serves the OSB API endpoints those are not provided by osb-broker-lib.
+type apiHandlers struct {
+	broker  *broker.Broker
+	metrics *metrics.OSBMetricsCollector
+}
+
+// GetCatalogHandler serves the catalog along with the fields of newer OSB API versions.
+func (h *apiHandlers) GetCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("get_catalog").Inc()
+
+	if err := h.broker.ValidateBrokerAPIVersion(r.Header.Get(osb.APIVersionHeader)); err != nil {
+		writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	c := &libbroker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := h.broker.GetServices(c)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, response)
+}
+
+// GetInstanceHandler serves GET requests on a service instance.
+func (h *apiHandlers) GetInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("get_instance").Inc()
+
+	if err := h.broker.ValidateBrokerAPIVersion(r.Header.Get(osb.APIVersionHeader)); err != nil {
+		writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request := &broker.GetInstanceRequest{
+		InstanceID: mux.Vars(r)[osb.VarKeyInstanceID],
+	}
+	identity, err := broker.OriginatingIdentity(r)
+	if err != nil {
+		glog.Infof("Unable to retrieve originating identity - %v", err)
+	}
+	request.OriginatingIdentity = identity
+	glog.V(4).Infof("Received GetInstanceRequest for instanceID %q", request.InstanceID)
+
+	c := &libbroker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := h.broker.GetInstance(request, c)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, response)
+}
+
+// GetBindingHandler serves GET requests on a service binding.
+func (h *apiHandlers) GetBindingHandler(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("get_binding").Inc()
+
+	if err := h.broker.ValidateBrokerAPIVersion(r.Header.Get(osb.APIVersionHeader)); err != nil {
+		writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	request := &osb.GetBindingRequest{
+		InstanceID: vars[osb.VarKeyInstanceID],
+		BindingID:  vars[osb.VarKeyBindingID],
+	}
+	glog.V(4).Infof("Received GetBindingRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &libbroker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := h.broker.GetBinding(request, c)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, response)
+}
+
+// BindHandler serves bind requests. Unlike the handler of osb-broker-lib, it responds
+// with 202 Accepted when the binding is in progress.
+func (h *apiHandlers) BindHandler(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("bind").Inc()
+
+	if err := h.broker.ValidateBrokerAPIVersion(r.Header.Get(osb.APIVersionHeader)); err != nil {
+		writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request := &osb.BindRequest{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	vars := mux.Vars(r)
+	request.InstanceID = vars[osb.VarKeyInstanceID]
+	request.BindingID = vars[osb.VarKeyBindingID]
+	// accepts_incomplete is a query parameter, as for the other asynchronous operations
+	if strings.ToLower(r.FormValue(osb.AcceptsIncomplete)) == "true" {
+		request.AcceptsIncomplete = true
+	}
+	identity, err := broker.OriginatingIdentity(r)
+	if err != nil {
+		glog.Infof("Unable to retrieve originating identity - %v", err)
+	}
+	request.OriginatingIdentity = identity
+	glog.V(4).Infof("Received BindRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &libbroker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := h.broker.Bind(request, c)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if response.Async {
+		status = http.StatusAccepted
+	}
+	if response.Exists {
+		status = http.StatusOK
+	}
+	writeResponse(w, status, response)
+}
+
+// BindingLastOperationHandler serves polling requests on the last operation of a service binding.
+func (h *apiHandlers) BindingLastOperationHandler(w http.ResponseWriter, r *http.Request) {
+	h.metrics.Actions.WithLabelValues("binding_last_operation").Inc()
+
+	if err := h.broker.ValidateBrokerAPIVersion(r.Header.Get(osb.APIVersionHeader)); err != nil {
+		writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	request := &osb.BindingLastOperationRequest{
+		InstanceID: vars[osb.VarKeyInstanceID],
+		BindingID:  vars[osb.VarKeyBindingID],
+	}
+	if serviceID := r.FormValue(osb.VarKeyServiceID); serviceID != "" {
+		request.ServiceID = &serviceID
+	}
+	if planID := r.FormValue(osb.VarKeyPlanID); planID != "" {
+		request.PlanID = &planID
+	}
+	if key := r.FormValue(osb.VarKeyOperation); key != "" {
+		operationKey := osb.OperationKey(key)
+		request.OperationKey = &operationKey
+	}
+	glog.V(4).Infof("Received BindingLastOperationRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &libbroker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := h.broker.BindingLastOperation(request, c)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, response)
+}
+
+// writeResponse serializes the object into the response the same way as osb-broker-lib does.
+func writeResponse(w http.ResponseWriter, code int, object interface{}) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// writeError writes an osb.HTTPStatusCodeError with its own status code,
+// other errors are written with the default status code.
+func writeError(w http.ResponseWriter, err error, defaultStatusCode int) {
+	type e struct {
+		ErrorMessage *string `json:"error,omitempty"`
+		Description  *string `json:"description,omitempty"`
+	}
+
+	if httpErr, ok := osb.IsHTTPError(err); ok {
+		writeResponse(w, httpErr.StatusCode, &e{
+			ErrorMessage: httpErr.ErrorMessage,
+			Description:  httpErr.Description,
+		})
+		return
+	}
+
+	description := err.Error()
+	writeResponse(w, defaultStatusCode, &e{
+		Description: &description,
+	})
+}
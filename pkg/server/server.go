@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	"github.com/appscode/service-broker/pkg/broker"
+	dbsvc "github.com/appscode/service-broker/pkg/kubedb"
 	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/metrics"
 	"github.com/pmorie/osb-broker-lib/pkg/rest"
 	prom "github.com/prometheus/client_golang/prometheus"
@@ -96,7 +98,16 @@ func (c completedConfig) New() (*BrokerServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	genericServer.Handler.NonGoRestfulMux.HandlePrefix("/v2/", registerAPIHandlers(api))
+	handlers := &apiHandlers{
+		broker:  b,
+		metrics: osbMetrics,
+	}
+	genericServer.Handler.NonGoRestfulMux.HandlePrefix("/v2/", waitForCaches(c.ExtraConfig.DBClient, registerAPIHandlers(api, handlers)))
+
+	// the broker is not healthy until the caches of the KubeDB objects are synced
+	genericServer.AddPostStartHookOrDie("start-kubedb-informers", func(context genericapiserver.PostStartHookContext) error {
+		return c.ExtraConfig.DBClient.Run(context.StopCh)
+	})
 
 	s := &BrokerServer{
 		GenericAPIServer: genericServer,
@@ -104,18 +115,38 @@ func (c completedConfig) New() (*BrokerServer, error) {
 	return s, nil
 }
 
-// registerAPIHandlers registers the APISurface endpoints and handlers.
-func registerAPIHandlers(api *rest.APISurface) http.Handler {
+// waitForCaches responds to the requests with 503 Service Unavailable, until the caches of the KubeDB objects are
+// synced, as the instances are looked up in the caches only.
+func waitForCaches(dbClient *dbsvc.Client, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !dbClient.HasSynced() {
+			description := "The caches of the KubeDB objects are not synced yet"
+			writeError(w, osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusServiceUnavailable,
+				Description: &description,
+			}, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerAPIHandlers registers the APISurface endpoints and handlers,
+// along with the endpoints those are not provided by the APISurface.
+func registerAPIHandlers(api *rest.APISurface, h *apiHandlers) http.Handler {
 	router := mux.NewRouter()
 	if api.EnableCORS {
 		router.Methods("OPTIONS").HandlerFunc(api.OptionsHandler)
 	}
-	router.HandleFunc("/v2/catalog", api.GetCatalogHandler).Methods("GET")
+	router.HandleFunc("/v2/catalog", h.GetCatalogHandler).Methods("GET")
 	router.HandleFunc("/v2/service_instances/{instance_id}/last_operation", api.LastOperationHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}", h.GetInstanceHandler).Methods("GET")
 	router.HandleFunc("/v2/service_instances/{instance_id}", api.ProvisionHandler).Methods("PUT")
 	router.HandleFunc("/v2/service_instances/{instance_id}", api.DeprovisionHandler).Methods("DELETE")
 	router.HandleFunc("/v2/service_instances/{instance_id}", api.UpdateHandler).Methods("PATCH")
-	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", api.BindHandler).Methods("PUT")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation", h.BindingLastOperationHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", h.GetBindingHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", h.BindHandler).Methods("PUT")
 	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", api.UnbindHandler).Methods("DELETE")
 	return router
 }
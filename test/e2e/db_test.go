@@ -11,6 +11,7 @@ import (
 	. "github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	kutil "kmodules.xyz/client-go"
 )
 
 var _ = Describe("[service-catalog]", func() {
@@ -294,7 +295,7 @@ var _ = Describe("[service-catalog]", func() {
 			waitForCRDBeReady = func() error {
 				my, err := f.KubedbClient.MySQLs(brokerNamespace).List(metav1.ListOptions{})
 				Expect(err).NotTo(HaveOccurred())
-				return dbsvc.WaitForMySQLBeReady(f.KubedbClient, my.Items[0].Name, brokerNamespace)
+				return dbsvc.WaitForMySQLBeReady(f.KubedbClient, my.Items[0].Name, brokerNamespace, kutil.ReadinessTimeout)
 			}
 		})
 
@@ -323,7 +324,7 @@ var _ = Describe("[service-catalog]", func() {
 			waitForCRDBeReady = func() error {
 				pg, err := f.KubedbClient.Postgreses(brokerNamespace).List(metav1.ListOptions{})
 				Expect(err).NotTo(HaveOccurred())
-				return dbsvc.WaitForPostgreSQLBeReady(f.KubedbClient, pg.Items[0].Name, brokerNamespace)
+				return dbsvc.WaitForPostgreSQLBeReady(f.KubedbClient, pg.Items[0].Name, brokerNamespace, kutil.ReadinessTimeout)
 			}
 
 		})
@@ -359,7 +360,7 @@ var _ = Describe("[service-catalog]", func() {
 			waitForCRDBeReady = func() error {
 				es, err := f.KubedbClient.Elasticsearches(brokerNamespace).List(metav1.ListOptions{})
 				Expect(err).NotTo(HaveOccurred())
-				return dbsvc.WaitForElasticsearchBeReady(f.KubedbClient, es.Items[0].Name, brokerNamespace)
+				return dbsvc.WaitForElasticsearchBeReady(f.KubedbClient, es.Items[0].Name, brokerNamespace, kutil.ReadinessTimeout)
 			}
 
 		})
@@ -395,7 +396,7 @@ var _ = Describe("[service-catalog]", func() {
 			waitForCRDBeReady = func() error {
 				mg, err := f.KubedbClient.MongoDBs(brokerNamespace).List(metav1.ListOptions{})
 				Expect(err).NotTo(HaveOccurred())
-				return dbsvc.WaitForMongoDbBeReady(f.KubedbClient, mg.Items[0].Name, brokerNamespace)
+				return dbsvc.WaitForMongoDbBeReady(f.KubedbClient, mg.Items[0].Name, brokerNamespace, kutil.ReadinessTimeout)
 			}
 		})
 
@@ -430,7 +431,7 @@ var _ = Describe("[service-catalog]", func() {
 			waitForCRDBeReady = func() error {
 				rd, err := f.KubedbClient.Redises(brokerNamespace).List(metav1.ListOptions{})
 				Expect(err).NotTo(HaveOccurred())
-				return dbsvc.WaitForRedisBeReady(f.KubedbClient, rd.Items[0].Name, brokerNamespace)
+				return dbsvc.WaitForRedisBeReady(f.KubedbClient, rd.Items[0].Name, brokerNamespace, kutil.ReadinessTimeout)
 			}
 		})
 
@@ -459,7 +460,7 @@ var _ = Describe("[service-catalog]", func() {
 			waitForCRDBeReady = func() error {
 				mc, err := f.KubedbClient.Memcacheds(brokerNamespace).List(metav1.ListOptions{})
 				Expect(err).NotTo(HaveOccurred())
-				return dbsvc.WaitForMemcachedBeReady(f.KubedbClient, mc.Items[0].Name, brokerNamespace)
+				return dbsvc.WaitForMemcachedBeReady(f.KubedbClient, mc.Items[0].Name, brokerNamespace, kutil.ReadinessTimeout)
 			}
 		})
 